@@ -1,119 +1,788 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/saurabhdhingra/backyard-backup/internal/archiver"
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
 	"github.com/saurabhdhingra/backyard-backup/internal/db"
+	"github.com/saurabhdhingra/backyard-backup/internal/format"
 	"github.com/saurabhdhingra/backyard-backup/internal/notify"
+	"github.com/saurabhdhingra/backyard-backup/internal/retry"
 	"github.com/saurabhdhingra/backyard-backup/internal/storage"
 	"github.com/spf13/cobra"
 )
 
+var backupAll bool
+
 var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Perform a database backup",
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := RunBackup(); err != nil {
+		if backupAll {
+			if jobName != "" {
+				fmt.Println("Error: --job and --all can't be used together")
+				os.Exit(1)
+			}
+			ctx, stop := signalContext()
+			defer stop()
+			if err := runAllBackups(ctx); err != nil {
+				fmt.Printf("Backup failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		job, err := selectJob()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		ctx, stop := signalContext()
+		defer stop()
+		if _, err := RunBackup(ctx, job); err != nil {
 			fmt.Printf("Backup failed: %v\n", err)
 			os.Exit(1)
 		}
 	},
 }
 
-// RunBackup performs the backup operation and returns an error if it fails.
+// artifact describes a backup file written to storage
+type artifact struct {
+	RemotePath string
+	RawSize    int64 // size of the dump before compression
+	Size       int64 // size of the stored file
+	SHA256     string
+
+	Stored []target // targets that hold the artifact
+	Failed []error  // upload failures of the other targets
+}
+
+// Backup steps that are retried, as named in BackupResult.Attempts. Uploads
+// are recorded per storage target as "upload to <name>".
+const (
+	stepConnect = "connect"
+	stepDump    = "dump"
+	stepStream  = "dump and upload"
+)
+
+// Phases of a backup, as timed in BackupResult.Phases
+const (
+	phaseConnect  = "connect"
+	phaseDump     = "dump"
+	phaseCompress = "compress" // Compression and encryption
+	phaseUpload   = "upload"
+)
+
+// phaseError marks the phase of a backup an error happened in
+type phaseError struct {
+	phase string
+	err   error
+}
+
+func (e *phaseError) Error() string { return e.err.Error() }
+func (e *phaseError) Unwrap() error { return e.err }
+
+// inPhase attributes err, if any, to a backup phase
+func inPhase(phase string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &phaseError{phase: phase, err: err}
+}
+
+// failedPhase returns the phase err happened in, or "" if it came from
+// none of them, such as a configuration error
+func failedPhase(err error) string {
+	var pe *phaseError
+	if errors.As(err, &pe) {
+		return pe.phase
+	}
+	return ""
+}
+
+// BackupResult summarizes one run of a backup job
+type BackupResult struct {
+	Job       string
+	Artifact  string
+	StartTime time.Time
+	Duration  time.Duration
+	RawSize   int64
+	Size      int64
+	Stored    []string       // Storage targets holding the backup
+	Locations []string       // Where each stored copy is, as "<target>: <location>"
+	Attempts  map[string]int // Attempts made per step
+
+	// Phases is the time spent in each phase. The phases of a streamed
+	// backup run concurrently, so their time is attributed to whichever
+	// the pipeline was waiting on.
+	Phases      map[string]time.Duration
+	FailedPhase string // Phase the run failed in, "" if it succeeded or failed outside any phase
+}
+
+// timePhase runs op, adding the time it took to the phase
+func (r *BackupResult) timePhase(phase string, op func() error) error {
+	start := time.Now()
+	err := op()
+	r.Phases[phase] += time.Since(start)
+	return err
+}
+
+// Retries describes the steps that needed more than one attempt, or "" if none did
+func (r *BackupResult) Retries() string {
+	var steps []string
+	for step, attempts := range r.Attempts {
+		if attempts > 1 {
+			steps = append(steps, fmt.Sprintf("%s: %d attempts", step, attempts))
+		}
+	}
+	sort.Strings(steps)
+	return strings.Join(steps, ", ")
+}
+
+// runAllBackups runs every configured job in turn, carrying on past failures.
+// It stops early if ctx is cancelled.
+func runAllBackups(ctx context.Context) error {
+	jobs, err := AppConfig.ResolveJobs()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopped before job %s: %w", job.Name, context.Cause(ctx))
+		}
+		fmt.Printf("==> Job %s\n", job.Name)
+		if _, err := RunBackup(ctx, job); err != nil {
+			fmt.Printf("Backup job %s failed: %v\n", job.Name, err)
+			failed = append(failed, job.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d jobs failed: %s", len(failed), len(jobs), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// RunBackup performs the backup operation for a job and returns an error if it fails.
 // This function can be called directly by the scheduler without risk of os.Exit.
-func RunBackup() error {
-	fmt.Println("Starting backup...")
-	startTime := time.Now()
+// It fails with an error wrapping lock.ErrLocked if the job is already running.
+// The result describes the run as far as it got, even if it failed, and is
+// reported to the job's notification channels and heartbeat either way.
+// Cancelling ctx, or the job's timeout expiring, aborts the run and
+// terminates any dump process it started.
+func RunBackup(ctx context.Context, job config.Job) (*BackupResult, error) {
+	result := &BackupResult{Job: job.Name, StartTime: time.Now(), Attempts: make(map[string]int), Phases: make(map[string]time.Duration)}
+	defer func() { result.Duration = time.Since(result.StartTime) }()
 
-	// 1. Initialize Database
-	dbConfig := db.Config{
-		Type:     AppConfig.Database.Type,
-		Host:     AppConfig.Database.Host,
-		Port:     AppConfig.Database.Port,
-		User:     AppConfig.Database.User,
-		Password: AppConfig.Database.Password,
-		DBName:   AppConfig.Database.DBName,
-		DSN:      AppConfig.Database.DSN,
+	// A run skipped because the job is already running isn't reported to
+	// the heartbeat monitor; the run holding the lock is
+	jobLock, err := lockJob(job)
+	if err != nil {
+		return result, err
+	}
+	defer jobLock.Release()
+
+	heartbeat := notify.NewHeartbeat(heartbeatConfig(job.Heartbeat))
+	if err := heartbeat.Start(ctx); err != nil {
+		fmt.Printf("Warning: failed to send start heartbeat: %v\n", err)
 	}
-	database, err := db.NewDatabase(dbConfig)
+
+	if timeout := job.Backup.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("backup job %s timed out after %s", job.Name, timeout))
+		defer cancel()
+	}
+
+	err = runBackup(ctx, job, result)
+	if err != nil && ctx.Err() != nil {
+		// Lead with why the run was cut short; the step's own error is
+		// usually just a killed process or a closed connection
+		err = fmt.Errorf("%w: %w", context.Cause(ctx), err)
+	}
+	result.FailedPhase = failedPhase(err)
+	result.Duration = time.Since(result.StartTime)
+
+	// The outcome is reported even if the run was cancelled, the same way
+	// for manual and scheduled runs
+	sendNotification(ctx, job.Notify, backupReport(job, result, err))
+	pingCtx := context.WithoutCancel(ctx)
+	if err != nil {
+		if pingErr := heartbeat.Failure(pingCtx, err); pingErr != nil {
+			fmt.Printf("Warning: failed to send failure heartbeat: %v\n", pingErr)
+		}
+	} else {
+		summary := fmt.Sprintf("Stored %s (%s) in %s", result.Artifact, format.Bytes(result.Size), strings.Join(result.Stored, ", "))
+		if pingErr := heartbeat.Success(pingCtx, summary); pingErr != nil {
+			fmt.Printf("Warning: failed to send success heartbeat: %v\n", pingErr)
+		}
+	}
+	return result, err
+}
+
+// runBackup performs one run of a job, filling in result as it goes
+func runBackup(ctx context.Context, job config.Job, result *BackupResult) error {
+	fmt.Printf("Starting backup job %s...\n", job.Name)
+	startTime := result.StartTime
+
+	// Validate the policies up front rather than after a long dump
+	policy, err := retentionPolicy(job.Retention)
+	if err != nil {
+		return fmt.Errorf("reading retention policy: %w", err)
+	}
+	retries, err := retryPolicy(job.Retry)
+	if err != nil {
+		return fmt.Errorf("reading retry policy: %w", err)
+	}
+	if _, err := newChannels(job.Notify); err != nil {
+		return fmt.Errorf("reading notify config: %w", err)
+	}
+
+	// 1. Initialize Database
+	database, err := newDatabase(job.Database)
 	if err != nil {
 		return fmt.Errorf("initializing database: %w", err)
 	}
 
-	if err := database.Connect(); err != nil {
-		return fmt.Errorf("connecting to database: %w", err)
+	err = result.timePhase(phaseConnect, func() error {
+		return retryStep(ctx, retries, result, stepConnect, database.Connect)
+	})
+	if err != nil {
+		return inPhase(phaseConnect, fmt.Errorf("connecting to database: %w", err))
 	}
 	defer database.Close()
 
 	// 2. Initialize Storage
-	storeConfig := storage.Config{
-		Type:      AppConfig.Storage.Type,
-		BasePath:  AppConfig.Storage.Path,
-		Bucket:    AppConfig.Storage.Bucket,
-		Region:    AppConfig.Storage.Region,
-		AccessKey: AppConfig.Storage.AccessKey,
-		SecretKey: AppConfig.Storage.SecretKey,
-	}
-	store, err := storage.NewStorage(storeConfig)
+	targets, err := newTargets(job)
 	if err != nil {
 		return fmt.Errorf("initializing storage: %w", err)
 	}
 
-	// 3. Create Temp Directory
+	// Record table sizes for `verify` to compare a test restore against
+	var tableCounts map[string]int64
+	if inspector, ok := database.(db.Inspector); ok && job.Backup.RecordCounts {
+		fmt.Println("Counting table rows...")
+		if tableCounts, err = inspector.TableCounts(ctx); err != nil {
+			fmt.Printf("Warning: failed to count table rows: %v\n", err)
+		}
+	}
+
+	// 3. Dump, compress and upload
+	var art *artifact
+	if streamer, ok := database.(db.Streamer); ok {
+		// The dump can't be replayed into a failed upload, so a streamed
+		// backup is retried as a whole
+		err = retryStep(ctx, retries, result, stepStream, func(ctx context.Context) error {
+			var err error
+			art, err = streamBackup(ctx, job, streamer, targets, result)
+			return err
+		})
+	} else {
+		art, err = stagedBackup(ctx, job, database, targets, retries, result)
+	}
+	if err != nil {
+		return err
+	}
+	result.Artifact, result.RawSize, result.Size = art.RemotePath, art.RawSize, art.Size
+	for _, t := range art.Stored {
+		result.Stored = append(result.Stored, t.Name)
+		result.Locations = append(result.Locations, t.Name+": "+t.ObjectLocation(art.RemotePath))
+	}
+
+	failures := art.Failed
+	for _, failure := range failures {
+		fmt.Printf("Warning: upload failed: %v\n", failure)
+	}
+
+	// 4. Write manifests and apply the retention policy on every target that holds the backup
+	manifest := newManifest(ctx, job, database, art, startTime)
+	manifest.TableCounts = tableCounts
+	for _, t := range art.Stored {
+		fmt.Printf("Backup uploaded to %s: %s\n", t.Name, art.RemotePath)
+		if err := catalog.New(t.Store).WriteManifest(ctx, manifest, uploadOptions(job, art)); err != nil {
+			failures = append(failures, fmt.Errorf("storage %s: writing manifest: %w", t.Name, err))
+			continue
+		}
+
+		if !policy.IsZero() {
+			fmt.Printf("Pruning old backups in %s...\n", t.Name)
+			if err := RunPrune(ctx, t.Store, policy, false); err != nil {
+				// The new backup is safely stored, so this is not fatal
+				fmt.Printf("Warning: failed to prune old backups: %v\n", err)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		err := fmt.Errorf("backup failed on %d of %d storage targets: %w", len(failures), len(targets), errors.Join(failures...))
+		return inPhase(phaseUpload, err)
+	}
+
+	result.Duration = time.Since(startTime)
+	successMsg := fmt.Sprintf("Backup job %s completed successfully in %s", job.Name, result.Duration)
+	if retried := result.Retries(); retried != "" {
+		successMsg += fmt.Sprintf(" (retried %s)", retried)
+	}
+	fmt.Println(successMsg)
+
+	return nil
+}
+
+// backupReport describes a backup run for notifications. err is the error
+// the run failed with, or nil.
+func backupReport(job config.Job, result *BackupResult, err error) notify.Report {
+	report := notify.Report{
+		Kind:     notify.KindBackup,
+		Status:   notify.StatusSuccess,
+		Job:      job.Name,
+		Database: databaseLabel(job.Database),
+		Artifact: result.Artifact,
+		RawSize:  result.RawSize,
+		Size:     result.Size,
+		Duration: result.Duration,
+		Storage:  result.Locations,
+		Attempts: result.Attempts,
+		Retries:  result.Retries(),
+		Time:     time.Now(),
+	}
+	if err != nil {
+		report.Status = notify.StatusFailure
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// retryStep runs one step of a backup under the job's retry policy,
+// recording the attempts it took in the result
+func retryStep(ctx context.Context, policy retry.Policy, result *BackupResult, step string, op func(context.Context) error) error {
+	attempts, err := retry.Do(ctx, policy, func() error { return op(ctx) }, func(attempt int, err error, wait time.Duration) {
+		fmt.Printf("Warning: %s failed (attempt %d of %d): %v\n", step, attempt, policy.MaxAttempts, err)
+		fmt.Printf("Retrying %s in %s...\n", step, wait.Round(time.Millisecond))
+	})
+	result.Attempts[step] += attempts
+	if err != nil && attempts > 1 {
+		return fmt.Errorf("after %d attempts: %w", attempts, err)
+	}
+	return err
+}
+
+// newManifest describes a finished backup for the catalog
+func newManifest(ctx context.Context, job config.Job, database db.Database, art *artifact, startTime time.Time) *catalog.Manifest {
+	engineVersion, err := database.Version(ctx)
+	if err != nil {
+		fmt.Printf("Warning: failed to read database version: %v\n", err)
+	}
+	hostname, _ := os.Hostname()
+
+	compression, compressionLevel, encryption := artifactFormat(job.Backup)
+
+	return &catalog.Manifest{
+		ID:               catalog.IDFromArtifact(art.RemotePath),
+		Artifact:         art.RemotePath,
+		Job:              job.Name,
+		DatabaseType:     job.Database.Type,
+		EngineVersion:    engineVersion,
+		DBName:           job.Database.DBName,
+		StartTime:        startTime.UTC(),
+		EndTime:          time.Now().UTC(),
+		RawSize:          art.RawSize,
+		Size:             art.Size,
+		SHA256:           art.SHA256,
+		Compression:      compression,
+		CompressionLevel: compressionLevel,
+		Encryption:       encryption,
+		ToolVersion:      Version,
+		Hostname:         hostname,
+		Tags:             job.Backup.Tags,
+	}
+}
+
+// artifactFormat returns the compression algorithm and level and the
+// encryption format the job's artifacts are written with
+func artifactFormat(cfg config.BackupConfig) (compression string, level int, encryption string) {
+	compression = archiver.CompressionNone
+	if c := cfg.Compression; c.Enabled() {
+		compression, level = c.Algorithm, c.Level
+	}
+	encryption = archiver.EncryptionNone
+	if cfg.Encryption.Enabled {
+		encryption = encryptionKeys(cfg.Encryption).Format()
+	}
+	return compression, level, encryption
+}
+
+// uploadOptions tags a job's artifacts and manifests with the job and
+// database, and records a summary of the backup in their metadata. The
+// checksum and sizes are left out when art is nil, as for a streamed
+// artifact whose upload starts before they are known.
+func uploadOptions(job config.Job, art *artifact) storage.UploadOptions {
+	compression, _, encryption := artifactFormat(job.Backup)
+	opts := storage.UploadOptions{
+		Tags: map[string]string{
+			"job":      job.Name,
+			"database": databaseLabel(job.Database),
+		},
+		Metadata: map[string]string{
+			"job":          job.Name,
+			"database":     databaseLabel(job.Database),
+			"compression":  compression,
+			"encryption":   encryption,
+			"tool-version": Version,
+		},
+	}
+	if art != nil {
+		opts.Metadata["sha256"] = art.SHA256
+		opts.Metadata["raw-size"] = strconv.FormatInt(art.RawSize, 10)
+		opts.Metadata["size"] = strconv.FormatInt(art.Size, 10)
+	}
+	return opts
+}
+
+// streamBackup pipes the dump through compression and encryption straight into
+// every storage target, so no part of the backup touches local disk. The
+// time spent is added to the result's phases.
+func streamBackup(ctx context.Context, job config.Job, streamer db.Streamer, targets []target, result *BackupResult) (*artifact, error) {
+	remotePath := streamer.DumpFileName() + artifactExtension(job.Backup)
+
+	fmt.Println("Streaming database dump to storage...")
+
+	var rawSize archiver.Counter
+	digest := archiver.NewDigest()
+
+	fan := &fanout{}
+	readers := make([]*io.PipeReader, len(targets))
+	for i := range targets {
+		pr, pw := io.Pipe()
+		readers[i] = pr
+		fan.writers = append(fan.writers, pw)
+	}
+	fan.failed = make([]bool, len(targets))
+
+	start := time.Now()
+	var timing streamTiming
+	var dumpEnd time.Time
+	dumpErr := make(chan error, 1)
+	go func() {
+		err := writeDump(ctx, job.Backup, streamer, io.MultiWriter(fan, digest), &rawSize, &timing)
+		dumpEnd = time.Now()
+		dumpErr <- err
+		fan.CloseWithError(err)
+	}()
+
+	opts := uploadOptions(job, nil)
+	uploadErrs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uploadErrs[i] = t.Store.StreamUpload(ctx, readers[i], remotePath, opts)
+			// Stop feeding this target; after a failure that lets the others carry on
+			readers[i].CloseWithError(uploadErrs[i])
+		}()
+	}
+	wg.Wait()
+
+	err := <-dumpErr
+	timing.attribute(result.Phases, time.Since(start), time.Since(dumpEnd))
+
+	// When every target has failed the dump was aborted by the uploads,
+	// so their errors are the root cause rather than the dump's
+	if err != nil && !fan.exhausted() {
+		return nil, inPhase(phaseDump, fmt.Errorf("dumping database: %w", err))
+	}
+
+	art := &artifact{
+		RemotePath: remotePath,
+		RawSize:    int64(rawSize),
+		Size:       digest.Size(),
+		SHA256:     digest.SHA256(),
+	}
+	for i, t := range targets {
+		if uploadErrs[i] != nil {
+			art.Failed = append(art.Failed, fmt.Errorf("storage %s: %w", t.Name, uploadErrs[i]))
+			continue
+		}
+		art.Stored = append(art.Stored, t)
+	}
+	if len(art.Stored) == 0 {
+		return nil, inPhase(phaseUpload, fmt.Errorf("uploading to storage: %w", errors.Join(art.Failed...)))
+	}
+
+	return art, nil
+}
+
+// streamTiming records where the writer side of a streamed backup spent its
+// time
+type streamTiming struct {
+	stages time.Duration // In the compression and encryption stages, including output
+	output time.Duration // Blocked writing to the storage uploads
+}
+
+// attribute splits the wall time of a streamed backup between its phases.
+// Time blocked on output, and the time uploads took to finish after the
+// dump ended, count as upload; time in the stages less their output as
+// compress; and the rest, spent waiting for the dump tool, as dump.
+func (t *streamTiming) attribute(phases map[string]time.Duration, total, tail time.Duration) {
+	upload := t.output + max(tail, 0)
+	compress := max(t.stages-t.output, 0)
+	phases[phaseUpload] += upload
+	phases[phaseCompress] += compress
+	phases[phaseDump] += max(total-upload-compress, 0)
+}
+
+// timedWriter adds the time spent in Write to elapsed
+type timedWriter struct {
+	w       io.Writer
+	elapsed *time.Duration
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	*t.elapsed += time.Since(start)
+	return n, err
+}
+
+// fanout copies writes to the pipes of several storage targets. A pipe whose
+// reader has gone away is dropped instead of failing the write, so one failed
+// target doesn't abort the upload to the others.
+type fanout struct {
+	writers []*io.PipeWriter
+	failed  []bool
+}
+
+func (f *fanout) Write(p []byte) (int, error) {
+	var lastErr error
+	for i, w := range f.writers {
+		if f.failed[i] {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			f.failed[i] = true
+			lastErr = err
+		}
+	}
+	if f.exhausted() {
+		if lastErr == nil {
+			lastErr = io.ErrClosedPipe
+		}
+		return 0, lastErr
+	}
+	return len(p), nil
+}
+
+// exhausted reports whether every target has failed
+func (f *fanout) exhausted() bool {
+	for _, failed := range f.failed {
+		if !failed {
+			return false
+		}
+	}
+	return true
+}
+
+// CloseWithError closes every pipe, passing err to the readers
+func (f *fanout) CloseWithError(err error) {
+	for _, w := range f.writers {
+		w.CloseWithError(err)
+	}
+}
+
+// writeDump writes the database dump to w through the compression and
+// encryption stages. The uncompressed size of the dump is added to rawSize,
+// and the time spent writing to timing.
+func writeDump(ctx context.Context, cfg config.BackupConfig, streamer db.Streamer, w io.Writer, rawSize *archiver.Counter, timing *streamTiming) error {
+	aw, err := newArtifactWriter(cfg, &timedWriter{w: w, elapsed: &timing.output})
+	if err != nil {
+		return err
+	}
+
+	stages := &timedWriter{w: aw, elapsed: &timing.stages}
+	if err := streamer.DumpTo(ctx, io.MultiWriter(stages, rawSize)); err != nil {
+		aw.Close()
+		return err
+	}
+	// Flushing the stages is part of their time
+	start := time.Now()
+	err = aw.Close()
+	timing.stages += time.Since(start)
+	return err
+}
+
+// artifactExtension returns the suffix the configured compression and
+// encryption stages add to a dump's file name
+func artifactExtension(cfg config.BackupConfig) string {
+	ext := ""
+	if c := cfg.Compression; c.Enabled() {
+		ext += archiver.Extension(c.Algorithm)
+	}
+	if cfg.Encryption.Enabled {
+		ext += encryptionKeys(cfg.Encryption).Extension()
+	}
+	return ext
+}
+
+// layeredWriter writes through a stack of stages. Close closes the stages in
+// order, innermost (closest to the data source) first.
+type layeredWriter struct {
+	io.Writer
+	stages []io.Closer
+}
+
+func (l *layeredWriter) Close() error {
+	for _, stage := range l.stages {
+		if err := stage.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newArtifactWriter wraps w with the configured compression and encryption
+// stages. Closing it flushes every stage but does not close w.
+func newArtifactWriter(cfg config.BackupConfig, w io.Writer) (io.WriteCloser, error) {
+	lw := &layeredWriter{Writer: w}
+
+	if cfg.Encryption.Enabled {
+		ew, err := archiver.NewEncryptWriter(lw.Writer, encryptionKeys(cfg.Encryption))
+		if err != nil {
+			return nil, fmt.Errorf("initializing encryption: %w", err)
+		}
+		lw.Writer = ew
+		lw.stages = append([]io.Closer{ew}, lw.stages...)
+	}
+
+	if c := cfg.Compression; c.Enabled() {
+		cw, err := archiver.NewWriter(lw.Writer, c.Algorithm, c.Level)
+		if err != nil {
+			return nil, fmt.Errorf("initializing compression: %w", err)
+		}
+		lw.Writer = cw
+		lw.stages = append([]io.Closer{cw}, lw.stages...)
+	}
+
+	return lw, nil
+}
+
+// stagedBackup dumps, compresses and encrypts into a temp directory before uploading.
+// It is the fallback for databases that cannot stream their dumps.
+func stagedBackup(ctx context.Context, job config.Job, database db.Database, targets []target, policy retry.Policy, result *BackupResult) (*artifact, error) {
 	tmpDir, err := os.MkdirTemp("", "backyard-backup")
 	if err != nil {
-		return fmt.Errorf("creating temp dir: %w", err)
+		return nil, fmt.Errorf("creating temp dir: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// 4. Dump Database
 	fmt.Println("Dumping database...")
-	dumpPath, err := database.Dump(tmpDir)
+	var dumpPath string
+	err = result.timePhase(phaseDump, func() error {
+		return retryStep(ctx, policy, result, stepDump, func(ctx context.Context) error {
+			var err error
+			dumpPath, err = database.Dump(ctx, tmpDir)
+			return err
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("dumping database: %w", err)
+		return nil, inPhase(phaseDump, fmt.Errorf("dumping database: %w", err))
 	}
 	fmt.Printf("Database dumped to: %s\n", dumpPath)
 
+	dumpInfo, err := os.Stat(dumpPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading dump file: %w", err)
+	}
+
 	finalPath := dumpPath
+	if ext := artifactExtension(job.Backup); ext != "" {
+		fmt.Println("Compressing and encrypting backup...")
+		finalPath = dumpPath + ext
+		err := result.timePhase(phaseCompress, func() error {
+			return writeArtifactFile(ctx, job.Backup, dumpPath, finalPath)
+		})
+		if err != nil {
+			return nil, inPhase(phaseCompress, err)
+		}
+		fmt.Printf("Backup written to: %s\n", finalPath)
+	}
+
+	digest, err := archiver.FileDigest(ctx, finalPath)
+	if err != nil {
+		return nil, fmt.Errorf("computing checksum: %w", err)
+	}
 
-	// 5. Compress if enabled
-	if AppConfig.Backup.Compression {
-		fmt.Println("Compressing backup...")
-		compressedPath := dumpPath + ".gz"
-		if err := archiver.Compress(dumpPath, compressedPath); err != nil {
-			return fmt.Errorf("compressing backup: %w", err)
+	art := &artifact{
+		RemotePath: filepath.Base(finalPath),
+		RawSize:    dumpInfo.Size(),
+		Size:       digest.Size(),
+		SHA256:     digest.SHA256(),
+	}
+	opts := uploadOptions(job, art)
+	for _, t := range targets {
+		fmt.Printf("Uploading to %s...\n", t.Name)
+		err := result.timePhase(phaseUpload, func() error {
+			return retryStep(ctx, policy, result, "upload to "+t.Name, func(ctx context.Context) error {
+				return t.Store.Upload(ctx, finalPath, art.RemotePath, opts)
+			})
+		})
+		if err != nil {
+			art.Failed = append(art.Failed, fmt.Errorf("storage %s: %w", t.Name, err))
+			continue
 		}
-		finalPath = compressedPath
-		fmt.Printf("Backup compressed to: %s\n", finalPath)
+		art.Stored = append(art.Stored, t)
 	}
+	if len(art.Stored) == 0 {
+		return nil, inPhase(phaseUpload, fmt.Errorf("uploading to storage: %w", errors.Join(art.Failed...)))
+	}
+
+	return art, nil
+}
 
-	// 6. Upload to Storage
-	fmt.Println("Uploading to storage...")
-	remotePath := filepath.Base(finalPath)
-	if err := store.Upload(finalPath, remotePath); err != nil {
-		return fmt.Errorf("uploading to storage: %w", err)
+// writeArtifactFile passes the dump at dumpPath through the compression and
+// encryption stages into a new file at destPath
+func writeArtifactFile(ctx context.Context, cfg config.BackupConfig, dumpPath, destPath string) error {
+	src, err := os.Open(dumpPath)
+	if err != nil {
+		return fmt.Errorf("opening dump file: %w", err)
 	}
+	defer src.Close()
 
-	duration := time.Since(startTime)
-	successMsg := fmt.Sprintf("Backup completed successfully in %s", duration)
-	fmt.Println(successMsg)
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("creating artifact file: %w", err)
+	}
+	defer dest.Close()
 
-	if AppConfig.Notify.Enabled && AppConfig.Notify.SlackWebhook != "" {
-		fmt.Println("Sending Slack notification...")
-		if err := notify.SendSlackNotification(AppConfig.Notify.SlackWebhook, successMsg); err != nil {
-			fmt.Printf("Warning: failed to send notification: %v\n", err)
-			// Don't fail the backup just because notification failed
-		}
+	aw, err := newArtifactWriter(cfg, dest)
+	if err != nil {
+		return err
+	}
+	if _, err := ctxio.Copy(ctx, aw, src); err != nil {
+		return fmt.Errorf("writing artifact file: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("writing artifact file: %w", err)
 	}
 
-	return nil
+	return dest.Close()
 }
 
 func init() {
 	rootCmd.AddCommand(backupCmd)
+	addJobFlag(backupCmd)
+	backupCmd.Flags().BoolVar(&backupAll, "all", false, "Run every configured job")
 }
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/archiver"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+	"github.com/saurabhdhingra/backyard-backup/internal/db"
+	"github.com/saurabhdhingra/backyard-backup/internal/lock"
+	"github.com/saurabhdhingra/backyard-backup/internal/retry"
+	"github.com/saurabhdhingra/backyard-backup/internal/storage"
+)
+
+// webhookRecorder is a webhook endpoint that keeps the payloads it receives
+type webhookRecorder struct {
+	mu       sync.Mutex
+	payloads []map[string]any
+}
+
+func newWebhookRecorder(t *testing.T) (*webhookRecorder, string) {
+	t.Helper()
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding webhook payload: %v", err)
+		}
+		rec.mu.Lock()
+		rec.payloads = append(rec.payloads, payload)
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return rec, srv.URL
+}
+
+func TestRunBackupNotifiesFailure(t *testing.T) {
+	rec, url := newWebhookRecorder(t)
+	onSuccess := false
+	job := config.Job{
+		Name:     "broken",
+		Database: config.DatabaseConfig{Type: "nosuchdb"},
+		Storages: []config.StorageTarget{{Name: "local", StorageConfig: config.StorageConfig{Type: "local", Path: t.TempDir()}}},
+		Backup:   config.BackupConfig{LockDir: t.TempDir()},
+		Notify:   config.NotifyConfig{{Type: "webhook", URL: url, OnSuccess: &onSuccess}},
+	}
+
+	// A manual run reports its failure without the scheduler's help
+	if _, err := RunBackup(context.Background(), job); err == nil {
+		t.Fatal("RunBackup succeeded with an unsupported database type")
+	}
+
+	if len(rec.payloads) != 1 {
+		t.Fatalf("webhook received %d notifications, want 1", len(rec.payloads))
+	}
+	got := rec.payloads[0]
+	if got["status"] != "failure" || got["job"] != "broken" || got["error"] == "" {
+		t.Errorf("notification = %v, want a failure of job broken with its error", got)
+	}
+}
+
+func TestRunBackupLocked(t *testing.T) {
+	rec, url := newWebhookRecorder(t)
+	job := config.Job{
+		Name:     "app",
+		Database: config.DatabaseConfig{Type: "nosuchdb"},
+		Backup:   config.BackupConfig{LockDir: t.TempDir()},
+		Notify:   config.NotifyConfig{{Type: "webhook", URL: url}},
+	}
+
+	held, err := lockJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A job that is already running is skipped without being reported
+	if _, err := RunBackup(context.Background(), job); !errors.Is(err, lock.ErrLocked) {
+		t.Fatalf("RunBackup() of a running job error = %v, want ErrLocked", err)
+	}
+	if len(rec.payloads) != 0 {
+		t.Errorf("webhook received %v for a skipped run", rec.payloads)
+	}
+
+	// Once released the run goes ahead, and fails on the database
+	if err := held.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunBackup(context.Background(), job); err == nil || errors.Is(err, lock.ErrLocked) {
+		t.Fatalf("RunBackup() after release error = %v, want the database error", err)
+	}
+}
+
+// fakeDatabase dumps a fixed payload. It streams its dumps unless wrapped
+// in stagedOnly.
+type fakeDatabase struct {
+	payload []byte
+	dumpErr error  // returned by DumpTo after half the payload
+	dumpDir string // directory Dump wrote to
+}
+
+func (f *fakeDatabase) Connect(ctx context.Context) error              { return nil }
+func (f *fakeDatabase) Restore(ctx context.Context, path string) error { return nil }
+func (f *fakeDatabase) Version(ctx context.Context) (string, error)    { return "fake 1.0", nil }
+func (f *fakeDatabase) Close() error                                   { return nil }
+func (f *fakeDatabase) DumpFileName() string                           { return "app_20240301_020000.sql" }
+
+func (f *fakeDatabase) Dump(ctx context.Context, destinationPath string) (string, error) {
+	f.dumpDir = destinationPath
+	path := filepath.Join(destinationPath, f.DumpFileName())
+	return path, os.WriteFile(path, f.payload, 0600)
+}
+
+func (f *fakeDatabase) DumpTo(ctx context.Context, w io.Writer) error {
+	if f.dumpErr != nil {
+		if _, err := w.Write(f.payload[:len(f.payload)/2]); err != nil {
+			return err
+		}
+		return f.dumpErr
+	}
+	_, err := w.Write(f.payload)
+	return err
+}
+
+// stagedOnly hides the Streamer methods of a database
+type stagedOnly struct{ db.Database }
+
+// failingStore fails every upload after reading the first bytes of it
+type failingStore struct{ storage.Storage }
+
+var errUploadFailed = errors.New("upload failed")
+
+func (f failingStore) Upload(ctx context.Context, localPath, remotePath string, opts storage.UploadOptions) error {
+	return errUploadFailed
+}
+
+func (f failingStore) StreamUpload(ctx context.Context, reader io.Reader, remotePath string, opts storage.UploadOptions) error {
+	if _, err := io.CopyN(io.Discard, reader, 1024); err != nil {
+		return err
+	}
+	return errUploadFailed
+}
+
+// testPayload returns a dump large enough to fill the upload pipes many times over
+func testPayload() []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 4<<20; i++ {
+		fmt.Fprintf(&buf, "INSERT INTO orders VALUES (%d, 'order %d');\n", i, i*7919)
+	}
+	return buf.Bytes()
+}
+
+// localTarget returns a target backed by a new local storage directory
+func localTarget(t *testing.T, name string) target {
+	t.Helper()
+	dir := t.TempDir()
+	return target{Name: name, Store: storage.NewLocal(storage.Config{Type: "local", BasePath: dir}), Location: dir}
+}
+
+// readArtifact returns the stored artifact at key and its decompressed contents
+func readArtifact(t *testing.T, store storage.Storage, key string) (stored, plain []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := store.StreamDownload(context.Background(), key, &buf); err != nil {
+		t.Fatalf("downloading %s: %v", key, err)
+	}
+	stored = buf.Bytes()
+	r, err := archiver.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if plain, err = io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	return stored, plain
+}
+
+func newTestResult() *BackupResult {
+	return &BackupResult{Attempts: make(map[string]int), Phases: make(map[string]time.Duration)}
+}
+
+func TestBackupPipelines(t *testing.T) {
+	payload := testPayload()
+	job := config.Job{Name: "app", Backup: config.BackupConfig{Compression: config.CompressionConfig{Algorithm: "gzip"}}}
+
+	tests := []struct {
+		name    string
+		staged  bool
+		targets []string // "ok" or "fail"
+	}{
+		{name: "stream to one target", targets: []string{"ok"}},
+		{name: "stream to every target", targets: []string{"ok", "ok", "ok"}},
+		{name: "stream past a failed target", targets: []string{"fail", "ok", "fail"}},
+		{name: "staged", staged: true, targets: []string{"ok", "ok"}},
+		{name: "staged past a failed target", staged: true, targets: []string{"ok", "fail"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var targets []target
+			for i, kind := range tt.targets {
+				tgt := localTarget(t, fmt.Sprintf("%s%d", kind, i))
+				if kind == "fail" {
+					tgt.Store = failingStore{tgt.Store}
+				}
+				targets = append(targets, tgt)
+			}
+
+			database := &fakeDatabase{payload: payload}
+			result := newTestResult()
+			var art *artifact
+			var err error
+			if tt.staged {
+				art, err = stagedBackup(ctx, job, stagedOnly{database}, targets, retry.Policy{}, result)
+			} else {
+				art, err = streamBackup(ctx, job, database, targets, result)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := database.DumpFileName() + ".gz"; art.RemotePath != want {
+				t.Errorf("RemotePath = %q, want %q", art.RemotePath, want)
+			}
+			if art.RawSize != int64(len(payload)) {
+				t.Errorf("RawSize = %d, want %d", art.RawSize, len(payload))
+			}
+
+			var stored, failed int
+			for _, tgt := range targets {
+				if _, ok := tgt.Store.(failingStore); ok {
+					failed++
+					continue
+				}
+				stored++
+				data, plain := readArtifact(t, tgt.Store, art.RemotePath)
+				if !bytes.Equal(plain, payload) {
+					t.Errorf("%s holds %d bytes of dump, want the %d written", tgt.Name, len(plain), len(payload))
+				}
+				sum := sha256.Sum256(data)
+				if art.SHA256 != hex.EncodeToString(sum[:]) || art.Size != int64(len(data)) {
+					t.Errorf("digest = %s (%d bytes), stored artifact has %x (%d bytes)", art.SHA256, art.Size, sum, len(data))
+				}
+			}
+			if len(art.Stored) != stored || len(art.Failed) != failed {
+				t.Errorf("stored on %d and failed on %d targets, want %d and %d", len(art.Stored), len(art.Failed), stored, failed)
+			}
+			for _, err := range art.Failed {
+				if !errors.Is(err, errUploadFailed) {
+					t.Errorf("failure %v doesn't wrap the upload error", err)
+				}
+			}
+
+			if tt.staged {
+				if _, err := os.Stat(database.dumpDir); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("temp dir %s left behind: %v", database.dumpDir, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupPipelineFailures(t *testing.T) {
+	job := config.Job{Name: "app"}
+	errDump := errors.New("dump tool crashed")
+
+	tests := []struct {
+		name      string
+		staged    bool
+		dumpErr   error
+		failAll   bool
+		wantErr   error
+		wantPhase string
+	}{
+		{name: "stream dump fails", dumpErr: errDump, wantErr: errDump, wantPhase: phaseDump},
+		{name: "stream to no working target", failAll: true, wantErr: errUploadFailed, wantPhase: phaseUpload},
+		{name: "staged to no working target", staged: true, failAll: true, wantErr: errUploadFailed, wantPhase: phaseUpload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			targets := []target{localTarget(t, "a"), localTarget(t, "b")}
+			if tt.failAll {
+				for i := range targets {
+					targets[i].Store = failingStore{targets[i].Store}
+				}
+			}
+
+			database := &fakeDatabase{payload: testPayload(), dumpErr: tt.dumpErr}
+			var err error
+			if tt.staged {
+				_, err = stagedBackup(ctx, job, stagedOnly{database}, targets, retry.Policy{}, newTestResult())
+			} else {
+				_, err = streamBackup(ctx, job, database, targets, newTestResult())
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if phase := failedPhase(err); phase != tt.wantPhase {
+				t.Errorf("failed in phase %q, want %q", phase, tt.wantPhase)
+			}
+
+			// An aborted stream mustn't leave a truncated artifact behind
+			for _, tgt := range targets {
+				if ok, err := tgt.Store.Exists(ctx, database.DumpFileName()); err != nil || ok {
+					t.Errorf("%s holds the artifact after a failed backup (%v)", tgt.Name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/archiver"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+	"github.com/saurabhdhingra/backyard-backup/internal/db"
+	"github.com/saurabhdhingra/backyard-backup/internal/lock"
+	"github.com/saurabhdhingra/backyard-backup/internal/notify"
+	"github.com/saurabhdhingra/backyard-backup/internal/retention"
+	"github.com/saurabhdhingra/backyard-backup/internal/retry"
+	"github.com/saurabhdhingra/backyard-backup/internal/storage"
+)
+
+// newDatabase builds the database provider for a database config block
+func newDatabase(cfg config.DatabaseConfig) (db.Database, error) {
+	return db.NewDatabase(databaseConfig(cfg))
+}
+
+// databaseLabel names a database as "<type>:<dbname>", for messages
+func databaseLabel(cfg config.DatabaseConfig) string {
+	if cfg.DBName == "" {
+		return cfg.Type
+	}
+	return cfg.Type + ":" + cfg.DBName
+}
+
+// databaseConfig converts a database config block for the db package
+func databaseConfig(cfg config.DatabaseConfig) db.Config {
+	return db.Config{
+		Type:     cfg.Type,
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		User:     cfg.User,
+		Password: cfg.Password,
+		DBName:   cfg.DBName,
+		DSN:      cfg.DSN,
+	}
+}
+
+// target is a storage backend a job writes its backups to
+type target struct {
+	Name     string
+	Store    storage.Storage
+	Location string // Where the job's backups are kept, e.g. "s3://bucket/orders"
+}
+
+// ObjectLocation returns where the object with the given key is kept in the target
+func (t target) ObjectLocation(key string) string {
+	return strings.TrimSuffix(t.Location, "/") + "/" + key
+}
+
+// jobBasePath returns the path within a storage target the job's backups
+// are kept under
+func jobBasePath(job config.Job, t config.StorageTarget) string {
+	if job.Prefix == "" {
+		return t.Path
+	}
+	if t.Type == "local" {
+		return filepath.Join(t.Path, job.Prefix)
+	}
+	return path.Join(t.Path, job.Prefix)
+}
+
+// storageLocation describes where a job's backups are kept in a storage
+// target, for messages
+func storageLocation(job config.Job, t config.StorageTarget) string {
+	basePath := jobBasePath(job, t)
+	switch t.Type {
+	case "local":
+		return basePath
+	case "s3", "aws":
+		return "s3://" + path.Join(t.Bucket, basePath)
+	case "gcs", "gs":
+		return "gs://" + path.Join(t.Bucket, basePath)
+	case "azure", "azblob":
+		return "azure://" + path.Join(t.Bucket, basePath)
+	default:
+		return t.Type + ":" + basePath
+	}
+}
+
+// newStorage builds the backend for one of a job's storage targets, rooted
+// at the job's prefix
+func newStorage(job config.Job, t config.StorageTarget) (storage.Storage, error) {
+	storeConfig := storage.Config{
+		Type:      t.Type,
+		BasePath:  jobBasePath(job, t),
+		Bucket:    t.Bucket,
+		Region:    t.Region,
+		AccessKey: t.AccessKey,
+		SecretKey: t.SecretKey,
+
+		CredentialsFile:    t.CredentialsFile,
+		AccountName:        t.AccountName,
+		AccountKey:         t.AccountKey,
+		SASToken:           t.SASToken,
+		ConnectionString:   t.ConnectionString,
+		SessionToken:       t.SessionToken,
+		Endpoint:           t.Endpoint,
+		PathStyle:          t.PathStyle,
+		CABundle:           t.CABundle,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+
+		SSE:          t.SSE,
+		KMSKeyID:     t.KMSKeyID,
+		StorageClass: t.StorageClass,
+		Tags:         t.ObjectTags,
+	}
+	if t.ObjectLock.Mode != "" || t.WORM {
+		period, err := lockPeriod(job, t)
+		if err != nil {
+			return nil, fmt.Errorf("storage %s: %w", t.Name, err)
+		}
+		storeConfig.LockMode = strings.ToUpper(t.ObjectLock.Mode)
+		storeConfig.LockPeriod = period
+		storeConfig.WORM = t.WORM
+	}
+	storeConfig.LegalHold = t.ObjectLock.LegalHold
+	if t.SSECustomerKeyFile != "" {
+		key, err := archiver.ReadKeyFile(t.SSECustomerKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("storage %s: reading SSE-C key: %w", t.Name, err)
+		}
+		storeConfig.SSECustomerKey = key
+	}
+	store, err := storage.NewStorage(storeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("storage %s: %w", t.Name, err)
+	}
+	return store, nil
+}
+
+// lockPeriod returns how long a storage target locks the job's backups: its
+// lock_period, or else the period derived from the job's retention policy
+func lockPeriod(job config.Job, t config.StorageTarget) (time.Duration, error) {
+	if t.LockPeriod != "" {
+		period, err := retention.ParseMaxAge(t.LockPeriod)
+		if err != nil {
+			return 0, fmt.Errorf("lock_period: %w", err)
+		}
+		return period, nil
+	}
+
+	policy, err := retentionPolicy(job.Retention)
+	if err != nil {
+		return 0, err
+	}
+	period := policy.LockPeriod()
+	if period == 0 && t.ObjectLock.Mode != "" {
+		return 0, fmt.Errorf("object lock needs a lock_period or a retention policy with keep_daily, keep_weekly, keep_monthly, keep_yearly or max_age")
+	}
+	return period, nil
+}
+
+// newTargets builds the backends for every storage target of a job
+func newTargets(job config.Job) ([]target, error) {
+	targets := make([]target, 0, len(job.Storages))
+	for _, t := range job.Storages {
+		store, err := newStorage(job, t)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{Name: t.Name, Store: store, Location: storageLocation(job, t)})
+	}
+	return targets, nil
+}
+
+// channel is one of a job's notification channels
+type channel struct {
+	Name      string
+	Notifier  notify.Notifier
+	OnSuccess bool
+	OnFailure bool
+}
+
+// newChannels builds the notifiers for a notify list
+func newChannels(cfg config.NotifyConfig) ([]channel, error) {
+	channels := make([]channel, 0, len(cfg))
+	for _, c := range cfg {
+		name := c.Name
+		if name == "" {
+			name = c.Type
+		}
+
+		notifier, err := notify.NewNotifier(notify.Config{
+			Type:          c.Type,
+			URL:           c.URL,
+			TitleTemplate: c.TitleTemplate,
+			Template:      c.Template,
+			Headers:       c.Headers,
+			Token:         c.Token,
+			Priority:      c.Priority,
+			SMTPHost:      c.SMTPHost,
+			SMTPPort:      c.SMTPPort,
+			Username:      c.Username,
+			Password:      c.Password,
+			From:          c.From,
+			To:            c.To,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %w", name, err)
+		}
+		channels = append(channels, channel{
+			Name:      name,
+			Notifier:  notifier,
+			OnSuccess: c.OnSuccess == nil || *c.OnSuccess,
+			OnFailure: c.OnFailure == nil || *c.OnFailure,
+		})
+	}
+	return channels, nil
+}
+
+// heartbeatConfig converts a heartbeat config block for the notify package
+func heartbeatConfig(cfg config.HeartbeatConfig) notify.HeartbeatConfig {
+	return notify.HeartbeatConfig{
+		URL:        cfg.URL,
+		StartURL:   cfg.StartURL,
+		SuccessURL: cfg.SuccessURL,
+		FailureURL: cfg.FailureURL,
+	}
+}
+
+// notifyTimeout bounds sending one message to all of a job's channels
+const notifyTimeout = 30 * time.Second
+
+// sendNotification reports a run to every channel that wants runs with its
+// status. Failures are only logged; a broken channel never fails a run. The
+// report is sent even if ctx has been cancelled, since that is usually what
+// it is about.
+func sendNotification(ctx context.Context, cfg config.NotifyConfig, report notify.Report) {
+	channels, err := newChannels(cfg)
+	if err != nil {
+		fmt.Printf("Warning: failed to send notification: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+	for _, c := range channels {
+		if (report.Status == notify.StatusSuccess && !c.OnSuccess) || (report.Status == notify.StatusFailure && !c.OnFailure) {
+			continue
+		}
+		fmt.Printf("Sending %s notification...\n", c.Name)
+		if err := c.Notifier.Notify(ctx, report); err != nil {
+			fmt.Printf("Warning: failed to send %s notification: %v\n", c.Name, err)
+		}
+	}
+}
+
+// lockJob takes the job's cross-process lock. The lock file is named after
+// the job and a hash of its database, so jobs of unrelated configs that share
+// a name (such as "default") don't block each other.
+func lockJob(job config.Job) (*lock.Lock, error) {
+	dir := job.Backup.LockDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "backyard-backup")
+	}
+
+	d := job.Database
+	sum := sha256.Sum256([]byte(strings.Join([]string{d.Type, d.Host, strconv.Itoa(d.Port), d.DBName, d.DSN}, "\x00")))
+	name := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, job.Name)
+
+	l, err := lock.Acquire(filepath.Join(dir, fmt.Sprintf("%s-%x.lock", name, sum[:4])))
+	if err != nil {
+		return nil, fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	return l, nil
+}
+
+// encryptionKeys returns the secrets configured for artifact encryption
+func encryptionKeys(cfg config.EncryptionConfig) archiver.EncryptionKeys {
+	return archiver.EncryptionKeys{
+		KeyFile:         cfg.KeyFile,
+		Passphrase:      cfg.Passphrase,
+		AgeRecipients:   cfg.AgeRecipients,
+		AgeIdentityFile: cfg.AgeIdentityFile,
+	}
+}
+
+// retentionPolicy converts a retention config block into a policy
+func retentionPolicy(cfg config.RetentionConfig) (retention.Policy, error) {
+	maxAge, err := retention.ParseMaxAge(cfg.MaxAge)
+	if err != nil {
+		return retention.Policy{}, err
+	}
+
+	return retention.Policy{
+		KeepLast:    cfg.KeepLast,
+		KeepDaily:   cfg.KeepDaily,
+		KeepWeekly:  cfg.KeepWeekly,
+		KeepMonthly: cfg.KeepMonthly,
+		KeepYearly:  cfg.KeepYearly,
+		MaxAge:      maxAge,
+	}, nil
+}
+
+// retryPolicy converts a retry config block into a policy
+func retryPolicy(cfg config.RetryConfig) (retry.Policy, error) {
+	retryable, err := retry.RetryableClasses(cfg.RetryOn)
+	if err != nil {
+		return retry.Policy{}, err
+	}
+
+	policy := retry.Policy{
+		MaxAttempts:    cfg.MaxAttempts,
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+		Multiplier:     cfg.Multiplier,
+		Jitter:         cfg.Jitter,
+		Retryable:      retryable,
+	}
+	if policy.InitialBackoff == 0 {
+		policy.InitialBackoff = 5 * time.Second
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = 5 * time.Minute
+	}
+	return policy, nil
+}
+
+// parseTime parses a timestamp given on the command line, either RFC 3339
+// or a plain date (interpreted as midnight UTC)
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 (2006-01-02T15:04:05Z) or a date (2006-01-02)", s)
+	}
+	return t, nil
+}
+
+// signalContext returns a context that is cancelled when the process gets
+// SIGINT or SIGTERM, so a command can stop its dump processes and clean up
+// before exiting. A second signal kills the process as usual.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Printf("\nReceived %s, stopping...\n", sig)
+			cancel(fmt.Errorf("received %s", sig))
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, func() { cancel(nil) }
+}
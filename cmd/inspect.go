@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/format"
+	"github.com/saurabhdhingra/backyard-backup/internal/retention"
+	"github.com/spf13/cobra"
+)
+
+var inspectOutput string
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <backup-id>",
+	Short: "Show the manifest, checksum and retention status of a backup",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		job, err := selectJob()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		policy, err := retentionPolicy(job.Retention)
+		if err != nil {
+			fmt.Printf("Error reading retention policy: %v\n", err)
+			os.Exit(1)
+		}
+
+		t, err := selectTarget(job)
+		if err != nil {
+			fmt.Printf("Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+		cat := catalog.New(t.Store)
+		entry, err := cat.Get(ctx, args[0])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Whether the policy keeps a backup depends on the others
+		entries, err := cat.Entries(ctx)
+		if err != nil {
+			fmt.Printf("Error listing backups: %v\n", err)
+			os.Exit(1)
+		}
+		backups := make([]catalog.Entry, 0, len(entries))
+		for _, e := range entries {
+			if e.IsBackup() {
+				backups = append(backups, e)
+			}
+		}
+
+		// Prune leaves alone files it doesn't recognise as backups
+		decision := retention.Decision{Keep: true, Reasons: []string{"not a backup"}}
+		for _, d := range retentionDecisions(backups, policy) {
+			if d.Backup.Key == entry.Artifact.Key {
+				decision = d
+				break
+			}
+		}
+
+		switch inspectOutput {
+		case "json":
+			err = printInspectJSON(entry, decision)
+		case "text":
+			err = printInspectText(entry, decision)
+		default:
+			err = fmt.Errorf("unsupported output format: %s", inspectOutput)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// retentionStatus is the JSON representation of a retention decision
+type retentionStatus struct {
+	Keep    bool     `json:"keep"`
+	Reasons []string `json:"reasons"`
+}
+
+func printInspectJSON(e *catalog.Entry, d retention.Decision) error {
+	out := struct {
+		listedBackup
+		Retention retentionStatus `json:"retention"`
+	}{
+		listedBackup: listedBackup{
+			ID:       e.ID,
+			Artifact: e.Artifact.Key,
+			Time:     e.Time(),
+			Size:     e.Artifact.Size,
+			Manifest: e.Manifest,
+		},
+		Retention: retentionStatus{Keep: d.Keep, Reasons: d.Reasons},
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func printInspectText(e *catalog.Entry, d retention.Decision) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(label, value string) {
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(w, "%s:\t%s\n", label, value)
+	}
+
+	row("ID", e.ID)
+	row("Artifact", e.Artifact.Key)
+	row("Stored size", fmt.Sprintf("%s (%d bytes)", format.Bytes(e.Artifact.Size), e.Artifact.Size))
+	row("Modified", e.Artifact.ModTime.Local().Format(time.RFC3339))
+
+	if m := e.Manifest; m != nil {
+		row("Job", m.Job)
+		row("Database", m.DatabaseType)
+		row("Database name", m.DBName)
+		row("Engine version", m.EngineVersion)
+		row("Started", m.StartTime.Local().Format(time.RFC3339))
+		row("Finished", m.EndTime.Local().Format(time.RFC3339))
+		row("Duration", m.EndTime.Sub(m.StartTime).Round(time.Millisecond).String())
+		row("Raw size", fmt.Sprintf("%s (%d bytes)", format.Bytes(m.RawSize), m.RawSize))
+		row("Compression", m.Compression)
+		row("Encryption", m.Encryption)
+		row("SHA-256", m.SHA256)
+		row("Tags", strings.Join(m.Tags, ", "))
+		row("Tool version", m.ToolVersion)
+		row("Hostname", m.Hostname)
+	} else {
+		row("Manifest", "none (backup predates manifests)")
+	}
+
+	status := "keep"
+	if !d.Keep {
+		status = "prune"
+	}
+	row("Retention", fmt.Sprintf("%s (%s)", status, strings.Join(d.Reasons, ", ")))
+
+	return w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+	addJobFlag(inspectCmd)
+	addStorageFlag(inspectCmd)
+	inspectCmd.Flags().StringVarP(&inspectOutput, "output", "o", "text", "Output format: text or json")
+}
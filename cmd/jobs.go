@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	jobName     string
+	storageName string
+)
+
+// addJobFlag registers --job on a command that acts on a single job
+func addJobFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&jobName, "job", "j", "", "Job to act on (required when several jobs are configured)")
+}
+
+// addStorageFlag registers --storage on a command that reads from one of a job's storage targets
+func addStorageFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&storageName, "storage", "", "Storage target to read from (defaults to the job's first)")
+}
+
+// selectJob returns the job named by --job, or the only configured job
+func selectJob() (config.Job, error) {
+	if jobName != "" {
+		return AppConfig.FindJob(jobName)
+	}
+
+	jobs, err := AppConfig.ResolveJobs()
+	if err != nil {
+		return config.Job{}, err
+	}
+	if len(jobs) == 1 {
+		return jobs[0], nil
+	}
+
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	return config.Job{}, fmt.Errorf("%d jobs configured, choose one with --job (%s)", len(jobs), strings.Join(names, ", "))
+}
+
+// selectTarget returns the job's storage target named by --storage, or its first
+func selectTarget(job config.Job) (target, error) {
+	t := job.Storages[0]
+	if storageName != "" {
+		found := false
+		for _, candidate := range job.Storages {
+			if candidate.Name == storageName {
+				t, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			return target{}, fmt.Errorf("job %s has no storage target %q", job.Name, storageName)
+		}
+	}
+
+	store, err := newStorage(job, t)
+	if err != nil {
+		return target{}, err
+	}
+	return target{Name: t.Name, Store: store, Location: storageLocation(job, t)}, nil
+}
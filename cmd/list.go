@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/db"
+	"github.com/saurabhdhingra/backyard-backup/internal/format"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listDatabase string
+	listSince    string
+	listUntil    string
+	listTag      string
+	listOutput   string
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the backups in storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		query := catalog.Query{Tag: listTag}
+		query.DatabaseType, query.DBName = parseDatabaseFilter(listDatabase)
+
+		var err error
+		if listSince != "" {
+			if query.Since, err = parseTime(listSince); err != nil {
+				fmt.Printf("Error: --since: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if listUntil != "" {
+			if query.Until, err = parseTime(listUntil); err != nil {
+				fmt.Printf("Error: --until: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		job, err := selectJob()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		t, err := selectTarget(job)
+		if err != nil {
+			fmt.Printf("Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+		entries, err := catalog.New(t.Store).Find(ctx, query)
+		if err != nil {
+			fmt.Printf("Error listing backups: %v\n", err)
+			os.Exit(1)
+		}
+
+		switch listOutput {
+		case "json":
+			err = printEntriesJSON(entries)
+		case "table":
+			err = printEntriesTable(entries)
+		default:
+			err = fmt.Errorf("unsupported output format: %s", listOutput)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// parseDatabaseFilter splits a --database value into a type and a name. It
+// takes the type:name form shown by the table, a bare type such as
+// "postgres", or a bare name for anything that isn't a known type.
+func parseDatabaseFilter(s string) (dbType, dbName string) {
+	if t, name, ok := strings.Cut(s, ":"); ok {
+		return t, name
+	}
+	if _, ok := db.CanonicalType(s); ok {
+		return s, ""
+	}
+	return "", s
+}
+
+// listedBackup is the JSON representation of a catalog entry
+type listedBackup struct {
+	ID       string            `json:"id"`
+	Artifact string            `json:"artifact"`
+	Time     time.Time         `json:"time"`
+	Size     int64             `json:"size"`
+	Manifest *catalog.Manifest `json:"manifest,omitempty"`
+}
+
+func printEntriesJSON(entries []catalog.Entry) error {
+	out := make([]listedBackup, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, listedBackup{
+			ID:       e.ID,
+			Artifact: e.Artifact.Key,
+			Time:     e.Time(),
+			Size:     e.Artifact.Size,
+			Manifest: e.Manifest,
+		})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func printEntriesTable(entries []catalog.Entry) error {
+	if len(entries) == 0 {
+		fmt.Println("No backups found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tDATABASE\tSIZE\tCOMPRESSION\tTAGS")
+	for _, e := range entries {
+		database, compression, tags := "-", "-", "-"
+		if m := e.Manifest; m != nil {
+			database = m.DatabaseType
+			if m.DBName != "" {
+				database += ":" + m.DBName
+			}
+			compression = m.Compression
+			if len(m.Tags) > 0 {
+				tags = strings.Join(m.Tags, ",")
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID,
+			e.Time().Local().Format("2006-01-02 15:04:05"),
+			database,
+			format.Bytes(e.Artifact.Size),
+			compression,
+			tags,
+		)
+	}
+	return w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	addJobFlag(listCmd)
+	addStorageFlag(listCmd)
+	listCmd.Flags().StringVar(&listDatabase, "database", "", "Only list backups of this database: type:name as shown in the table, a type such as postgres, or a name")
+	listCmd.Flags().StringVar(&listSince, "since", "", "Only list backups taken at or after this time (RFC 3339 or YYYY-MM-DD)")
+	listCmd.Flags().StringVar(&listUntil, "until", "", "Only list backups taken at or before this time (RFC 3339 or YYYY-MM-DD)")
+	listCmd.Flags().StringVar(&listTag, "tag", "", "Only list backups carrying this tag")
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "Output format: table or json")
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/retention"
+	"github.com/saurabhdhingra/backyard-backup/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var pruneDryRun bool
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete backups that fall outside the retention policy",
+	Run: func(cmd *cobra.Command, args []string) {
+		job, err := selectJob()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		policy, err := retentionPolicy(job.Retention)
+		if err != nil {
+			fmt.Printf("Error reading retention policy: %v\n", err)
+			os.Exit(1)
+		}
+		if policy.IsZero() {
+			fmt.Println("No retention policy configured, nothing to prune")
+			return
+		}
+
+		targets, err := newTargets(job)
+		if err != nil {
+			fmt.Printf("Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		failed := false
+		for _, t := range targets {
+			if storageName != "" && t.Name != storageName {
+				continue
+			}
+			fmt.Printf("Pruning %s...\n", t.Name)
+			if err := RunPrune(ctx, t.Store, policy, pruneDryRun); err != nil {
+				fmt.Printf("Prune of %s failed: %v\n", t.Name, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// RunPrune applies the retention policy to the backups in store, deleting
+// those it doesn't keep. With dryRun set it only prints what would be deleted.
+func RunPrune(ctx context.Context, store storage.Storage, policy retention.Policy, dryRun bool) error {
+	cat := catalog.New(store)
+	all, err := cat.Entries(ctx)
+	if err != nil {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+
+	// The storage location may hold files that aren't ours, and the rules
+	// would happily drop those too
+	var entries []catalog.Entry
+	for _, e := range all {
+		if e.IsBackup() {
+			entries = append(entries, e)
+		}
+	}
+	if skipped := len(all) - len(entries); skipped > 0 {
+		fmt.Printf("Ignoring %d files that aren't backups\n", skipped)
+	}
+
+	byKey := make(map[string]catalog.Entry, len(entries))
+	for _, e := range entries {
+		byKey[e.Artifact.Key] = e
+	}
+
+	var deleted, locked, failed int
+	for _, d := range retentionDecisions(entries, policy) {
+		if d.Keep {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("pruning stopped after %d deletions: %w", deleted, context.Cause(ctx))
+		}
+
+		// Deleting a locked S3 object only hides it behind a delete marker
+		lock, err := lockStatus(ctx, store, byKey[d.Backup.Key])
+		if err != nil {
+			fmt.Printf("Warning: failed to check the lock on %s: %v\n", d.Backup.Key, err)
+			failed++
+			continue
+		}
+		if lock != "" {
+			fmt.Printf("Keeping %s (%s)\n", d.Backup.Key, lock)
+			locked++
+			continue
+		}
+
+		reason := strings.Join(d.Reasons, ", ")
+		if dryRun {
+			fmt.Printf("Would delete %s (%s)\n", d.Backup.Key, reason)
+			deleted++
+			continue
+		}
+
+		fmt.Printf("Deleting %s (%s)\n", d.Backup.Key, reason)
+		if err := cat.Delete(ctx, byKey[d.Backup.Key]); err != nil {
+			fmt.Printf("Warning: failed to delete %s: %v\n", d.Backup.Key, err)
+			failed++
+			continue
+		}
+		deleted++
+	}
+
+	if dryRun {
+		fmt.Printf("Dry run: %d of %d backups would be deleted\n", deleted, len(entries))
+	} else {
+		fmt.Printf("Pruned %d of %d backups\n", deleted, len(entries))
+	}
+	if locked > 0 {
+		fmt.Printf("%d backups outside the retention policy are still locked\n", locked)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d backups", failed)
+	}
+	return nil
+}
+
+// lockStatus describes why a backup's artifact or manifest can't be deleted
+// yet, or returns "" if neither is locked
+func lockStatus(ctx context.Context, store storage.Storage, e catalog.Entry) (string, error) {
+	keys := []string{e.Artifact.Key}
+	if e.Manifest != nil {
+		keys = append(keys, catalog.ManifestPath(e.Artifact.Key))
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		info, err := store.Stat(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.LegalHold {
+			return "under legal hold", nil
+		}
+		if info.RetainUntil.After(now) {
+			return "locked until " + info.RetainUntil.Local().Format(time.RFC3339), nil
+		}
+	}
+	return "", nil
+}
+
+// retentionDecisions evaluates the policy against the catalog entries
+func retentionDecisions(entries []catalog.Entry, policy retention.Policy) []retention.Decision {
+	backups := make([]retention.Backup, 0, len(entries))
+	for _, e := range entries {
+		backups = append(backups, retention.Backup{Key: e.Artifact.Key, Time: e.Time()})
+	}
+	return retention.Apply(policy, backups, time.Now())
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	addJobFlag(pruneCmd)
+	pruneCmd.Flags().StringVar(&storageName, "storage", "", "Only prune this storage target (defaults to all of the job's targets)")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Print the backups that would be deleted without deleting them")
+}
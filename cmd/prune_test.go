@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/retention"
+	"github.com/saurabhdhingra/backyard-backup/internal/storage"
+)
+
+// putFile stores data at key in store
+func putFile(t *testing.T, store storage.Storage, key, data string) {
+	t.Helper()
+	if err := store.StreamUpload(context.Background(), strings.NewReader(data), key, storage.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunPruneIgnoresForeignFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := storage.NewLocal(storage.Config{Type: "local", BasePath: dir})
+	cat := catalog.New(store)
+
+	now := time.Now()
+	dumpName := func(daysAgo int) string {
+		return "app_" + now.AddDate(0, 0, -daysAgo).Format("20060102_150405") + ".sql.gz"
+	}
+
+	// Two dumps by name, one with only a manifest to identify it, and files
+	// that merely share the directory: all older than the newest backup
+	putFile(t, store, dumpName(1), "new")
+	putFile(t, store, dumpName(5), "old")
+	putFile(t, store, "export.bin", "manifested")
+	if err := cat.WriteManifest(ctx, &catalog.Manifest{Artifact: "export.bin", StartTime: now.AddDate(0, 0, -3)}, storage.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	foreign := []string{"notes.txt", "photos/cat.jpg"}
+	for _, key := range foreign {
+		putFile(t, store, key, "not a backup")
+		old := now.AddDate(0, 0, -10)
+		if err := os.Chtimes(filepath.Join(dir, key), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := RunPrune(ctx, store, retention.Policy{KeepLast: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		kept bool
+	}{
+		{key: dumpName(1), kept: true},
+		{key: dumpName(5), kept: false},
+		{key: "export.bin", kept: false},
+		{key: catalog.ManifestPath("export.bin"), kept: false},
+		{key: "notes.txt", kept: true},
+		{key: "photos/cat.jpg", kept: true},
+	}
+	for _, tt := range tests {
+		ok, err := store.Exists(ctx, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.kept {
+			t.Errorf("%s exists = %t after prune, want %t", tt.key, ok, tt.kept)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,64 +10,96 @@ import (
 	"time"
 
 	"github.com/saurabhdhingra/backyard-backup/internal/archiver"
-	"github.com/saurabhdhingra/backyard-backup/internal/db"
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
 	"github.com/saurabhdhingra/backyard-backup/internal/storage"
 	"github.com/spf13/cobra"
 )
 
-var restoreFile string
+var (
+	restoreFile   string
+	restoreLatest bool
+	restoreBefore string
+)
 
 var restoreCmd = &cobra.Command{
 	Use:   "restore",
 	Short: "Restore a database from a backup",
 	Run: func(cmd *cobra.Command, args []string) {
-		if restoreFile == "" {
-			fmt.Println("Error: --file flag is required")
+		selectors := 0
+		for _, set := range []bool{restoreFile != "", restoreLatest, restoreBefore != ""} {
+			if set {
+				selectors++
+			}
+		}
+		if selectors != 1 {
+			fmt.Println("Error: exactly one of --file, --latest or --before is required")
+			os.Exit(1)
+		}
+
+		var before time.Time
+		if restoreBefore != "" {
+			var err error
+			if before, err = parseTime(restoreBefore); err != nil {
+				fmt.Printf("Error: --before: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		job, err := selectJob()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 
-		fmt.Println("Starting restore...")
+		ctx, stop := signalContext()
+		defer stop()
+
+		fmt.Printf("Starting restore of job %s...\n", job.Name)
 		startTime := time.Now()
 
 		// 1. Initialize Database
-		dbConfig := db.Config{
-			Type:     AppConfig.Database.Type,
-			Host:     AppConfig.Database.Host,
-			Port:     AppConfig.Database.Port,
-			User:     AppConfig.Database.User,
-			Password: AppConfig.Database.Password,
-			DBName:   AppConfig.Database.DBName,
-			DSN:      AppConfig.Database.DSN,
-		}
-		database, err := db.NewDatabase(dbConfig)
+		database, err := newDatabase(job.Database)
 		if err != nil {
 			fmt.Printf("Error initializing database: %v\n", err)
 			os.Exit(1)
 		}
 		// Note: For restore, we might need a connection, but pg_dump/psql usually handles it via cli args.
 		// However, establishing checking connectivity is good practice.
-		if err := database.Connect(); err != nil {
+		if err := database.Connect(ctx); err != nil {
 			fmt.Printf("Error connecting to database: %v\n", err)
 			os.Exit(1)
 		}
 		defer database.Close()
 
 		// 2. Initialize Storage
-		storeConfig := storage.Config{
-			Type:      AppConfig.Storage.Type,
-			BasePath:  AppConfig.Storage.Path,
-			Bucket:    AppConfig.Storage.Bucket,
-			Region:    AppConfig.Storage.Region,
-			AccessKey: AppConfig.Storage.AccessKey,
-			SecretKey: AppConfig.Storage.SecretKey,
-		}
-		store, err := storage.NewStorage(storeConfig)
+		t, err := selectTarget(job)
 		if err != nil {
 			fmt.Printf("Error initializing storage: %v\n", err)
 			os.Exit(1)
 		}
 
-		// 3. Create Temp Directory
+		// 3. Resolve which backup to restore
+		cat := catalog.New(t.Store)
+		var manifest *catalog.Manifest
+		if restoreFile == "" {
+			entry, err := cat.LatestBefore(ctx, before)
+			if err != nil {
+				fmt.Printf("Error finding backup: %v\n", err)
+				os.Exit(1)
+			}
+			restoreFile = entry.Artifact.Key
+			manifest = entry.Manifest
+			fmt.Printf("Selected backup %s taken at %s\n", entry.ID, entry.Time().Local().Format(time.RFC3339))
+		} else {
+			manifest, err = cat.ReadManifest(ctx, restoreFile)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				fmt.Printf("Error reading manifest: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// 4. Create Temp Directory
 		tmpDir, err := os.MkdirTemp("", "backyard-restore")
 		if err != nil {
 			fmt.Printf("Error creating temp dir: %v\n", err)
@@ -73,31 +107,16 @@ var restoreCmd = &cobra.Command{
 		}
 		defer os.RemoveAll(tmpDir)
 
-		// 4. Download from Storage
-		localDownloadPath := filepath.Join(tmpDir, filepath.Base(restoreFile))
-		fmt.Printf("Downloading backup from storage: %s\n", restoreFile)
-		if err := store.Download(restoreFile, localDownloadPath); err != nil {
-			fmt.Printf("Error downloading file: %v\n", err)
+		// 5. Download, verify and decompress
+		finalRestorePath, err := fetchBackup(ctx, t.Store, restoreFile, manifest, job.Backup.Encryption, tmpDir)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 
-		finalRestorePath := localDownloadPath
-
-		// 5. Decompress if needed
-		// Simple check: if ends with .gz
-		if strings.HasSuffix(restoreFile, ".gz") {
-			fmt.Println("Decompressing backup...")
-			decompressedPath := strings.TrimSuffix(localDownloadPath, ".gz")
-			if err := archiver.Decompress(localDownloadPath, decompressedPath); err != nil {
-				fmt.Printf("Error decompressing file: %v\n", err)
-				os.Exit(1)
-			}
-			finalRestorePath = decompressedPath
-		}
-
 		// 6. Restore to Database
 		fmt.Println("Restoring to database...")
-		if err := database.Restore(finalRestorePath); err != nil {
+		if err := database.Restore(ctx, finalRestorePath); err != nil {
 			fmt.Printf("Error restoring database: %v\n", err)
 			os.Exit(1)
 		}
@@ -107,7 +126,71 @@ var restoreCmd = &cobra.Command{
 	},
 }
 
+// fetchBackup downloads a backup into dir, checks it against its manifest
+// (if any), then decrypts and decompresses it. It returns the path of the file to restore.
+func fetchBackup(ctx context.Context, store storage.Storage, remotePath string, manifest *catalog.Manifest, encryption config.EncryptionConfig, dir string) (string, error) {
+	localDownloadPath := filepath.Join(dir, filepath.Base(remotePath))
+	fmt.Printf("Downloading backup from storage: %s\n", remotePath)
+	if err := store.Download(ctx, remotePath, localDownloadPath); err != nil {
+		return "", fmt.Errorf("downloading file: %w", err)
+	}
+
+	// Verify integrity before anything reads the file
+	if manifest != nil {
+		fmt.Println("Verifying checksum...")
+		if err := manifest.Verify(ctx, localDownloadPath); err != nil {
+			return "", fmt.Errorf("verifying backup: %w", err)
+		}
+	} else {
+		fmt.Println("Warning: backup has no manifest, skipping checksum verification")
+	}
+
+	path := localDownloadPath
+
+	format, err := archiver.DetectFileEncryption(path)
+	if err != nil {
+		return "", fmt.Errorf("reading backup: %w", err)
+	}
+	if format != archiver.EncryptionNone {
+		fmt.Printf("Decrypting backup (%s)...\n", format)
+		decryptedPath := strings.TrimSuffix(strings.TrimSuffix(path, ".enc"), ".age")
+		if decryptedPath == path {
+			decryptedPath += ".decrypted"
+		}
+		if err := archiver.Decrypt(ctx, path, decryptedPath, encryptionKeys(encryption)); err != nil {
+			return "", fmt.Errorf("decrypting file: %w", err)
+		}
+		// Intermediate files are no longer needed; free the disk space
+		os.Remove(path)
+		path = decryptedPath
+	}
+
+	compression, err := archiver.DetectFileCompression(path)
+	if err != nil {
+		return "", fmt.Errorf("reading backup: %w", err)
+	}
+	if compression == archiver.CompressionNone {
+		return path, nil
+	}
+
+	fmt.Printf("Decompressing backup (%s)...\n", compression)
+	decompressedPath := strings.TrimSuffix(path, archiver.Extension(compression))
+	if decompressedPath == path {
+		decompressedPath += ".decompressed"
+	}
+	if err := archiver.Decompress(ctx, path, decompressedPath); err != nil {
+		return "", fmt.Errorf("decompressing file: %w", err)
+	}
+	os.Remove(path)
+
+	return decompressedPath, nil
+}
+
 func init() {
 	rootCmd.AddCommand(restoreCmd)
+	addJobFlag(restoreCmd)
+	addStorageFlag(restoreCmd)
 	restoreCmd.Flags().StringVarP(&restoreFile, "file", "f", "", "Path to the backup file in storage to restore")
+	restoreCmd.Flags().BoolVar(&restoreLatest, "latest", false, "Restore the most recent backup")
+	restoreCmd.Flags().StringVar(&restoreBefore, "before", "", "Restore the most recent backup taken at or before this time (RFC 3339 or YYYY-MM-DD)")
 }
@@ -11,12 +11,17 @@ import (
 var cfgFile string
 var AppConfig *config.Config
 
+// Version is the release version, set at build time with
+// -ldflags "-X github.com/saurabhdhingra/backyard-backup/cmd.Version=v1.2.3"
+var Version = "dev"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "dbbackup",
 	Short: "A CLI utility for backing up databases",
 	Long: `Backyard Backup is a CLI tool to backup and restore various databases
 supported (PostgreSQL, MySQL, MongoDB, SQLite) to local or cloud storage.`,
+	Version: Version,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -1,14 +1,25 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/robfig/cron/v3"
-	"github.com/saurabhdhingra/backyard-backup/internal/notify"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+	"github.com/saurabhdhingra/backyard-backup/internal/lock"
+	"github.com/saurabhdhingra/backyard-backup/internal/metrics"
 	"github.com/spf13/cobra"
 )
 
@@ -16,55 +27,437 @@ var scheduleCmd = &cobra.Command{
 	Use:   "schedule",
 	Short: "Run backup on a schedule",
 	Run: func(cmd *cobra.Command, args []string) {
-		schedule := AppConfig.Backup.Schedule
-		if schedule == "" {
-			fmt.Println("Error: No schedule defined in config")
+		jobs, err := AppConfig.ResolveJobs()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 
+		// Running backups get their own context so that a second signal can
+		// abort them; stopping is closed on the first to drop queued runs
+		ctx, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
 		c := cron.New()
-		_, err := c.AddFunc(schedule, func() {
-			fmt.Printf("[%s] Running scheduled backup...\n", time.Now().Format(time.RFC3339))
-
-			if err := RunBackup(); err != nil {
-				fmt.Printf("[%s] Scheduled backup failed: %v\n", time.Now().Format(time.RFC3339), err)
-				// Send failure notification if configured
-				if AppConfig.Notify.Enabled && AppConfig.Notify.SlackWebhook != "" {
-					notifyErr := notifyBackupFailure(err)
-					if notifyErr != nil {
-						fmt.Printf("Warning: failed to send failure notification: %v\n", notifyErr)
-					}
-				}
-				return
+		s := &scheduler{
+			cron:     c,
+			stopping: make(chan struct{}),
+			metrics:  metrics.New(),
+			status:   make(map[string]*jobStatus),
+		}
+
+		for _, job := range jobs {
+			if job.Backup.Schedule == "" {
+				fmt.Printf("Job %s has no schedule, skipping\n", job.Name)
+				continue
 			}
 
-			fmt.Printf("[%s] Scheduled backup completed successfully\n", time.Now().Format(time.RFC3339))
-		})
+			spec, err := cronSpec(job.Backup)
+			if err != nil {
+				fmt.Printf("Error scheduling job %s: %v\n", job.Name, err)
+				os.Exit(1)
+			}
+			wrapper, err := overlapWrapper(job)
+			if err != nil {
+				fmt.Printf("Error scheduling job %s: %v\n", job.Name, err)
+				os.Exit(1)
+			}
+			run := cron.FuncJob(func() { s.runScheduledBackup(ctx, job) })
+			id, err := c.AddJob(spec, cron.NewChain(wrapper).Then(run))
+			if err != nil {
+				fmt.Printf("Error adding cron job %s: %v\n", job.Name, err)
+				os.Exit(1)
+			}
+			s.jobs = append(s.jobs, scheduledJob{job: job, id: id})
+			s.status[job.Name] = &jobStatus{}
+			s.metrics.AddJob(job.Name)
+		}
 
+		if len(s.jobs) == 0 {
+			fmt.Println("Error: No schedule defined in config")
+			os.Exit(1)
+		}
+
+		server, err := serveHTTP(AppConfig.HTTP, s)
 		if err != nil {
-			fmt.Printf("Error adding cron job: %v\n", err)
+			fmt.Printf("Error starting HTTP listener: %v\n", err)
 			os.Exit(1)
 		}
 
 		c.Start()
-		fmt.Printf("Backup scheduler started with schedule: %s\n", schedule)
+		s.ready.Store(true)
+		fmt.Println("Backup scheduler started")
+		if server != nil {
+			fmt.Printf("Serving metrics, health and status on http://%s\n", server.Addr)
+		}
+		printSchedule(c, s.jobs)
 		fmt.Println("Press Ctrl+C to stop the scheduler")
 
 		// Handle graceful shutdown
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
+		s.ready.Store(false)
+		close(s.stopping)
 
 		fmt.Println("\nShutting down scheduler...")
-		c.Stop()
+		// Stop only prevents new runs; wait for backups already in progress
+		stopped := c.Stop()
+		if n := runningBackups.Load(); n > 0 {
+			fmt.Printf("Waiting for %d running backups to finish (signal again to cancel them)...\n", n)
+		}
+		select {
+		case <-stopped.Done():
+		case sig := <-sigChan:
+			// Let a further signal kill the process if cleanup hangs
+			signal.Stop(sigChan)
+			fmt.Printf("Received %s, cancelling running backups...\n", sig)
+			cancel(errors.New("scheduler shut down"))
+			<-stopped.Done()
+		}
+		if server != nil {
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), httpShutdownTimeout)
+			server.Shutdown(shutdownCtx)
+			cancelShutdown()
+		}
 		fmt.Println("Scheduler stopped")
 	},
 }
 
-// notifyBackupFailure sends a Slack notification about backup failure
-func notifyBackupFailure(err error) error {
-	msg := fmt.Sprintf("🚨 Backup failed: %v", err)
-	return notify.SendSlackNotification(AppConfig.Notify.SlackWebhook, msg)
+// Overlap modes for a job that is due while its previous run is still going
+const (
+	overlapSkip  = "skip"
+	overlapQueue = "queue"
+)
+
+// lockPollInterval is how often a queued run checks whether another process
+// has finished the job
+const lockPollInterval = 10 * time.Second
+
+// httpShutdownTimeout bounds how long the scheduler waits for open HTTP
+// requests when it stops
+const httpShutdownTimeout = 5 * time.Second
+
+// runningBackups counts scheduled backups in progress
+var runningBackups atomic.Int32
+
+// scheduler holds the state shared by the runs of the schedule command
+type scheduler struct {
+	cron     *cron.Cron
+	jobs     []scheduledJob
+	stopping chan struct{} // Closed on the first signal to drop queued runs
+	ready    atomic.Bool   // Set while the scheduler is starting runs
+	metrics  *metrics.Metrics
+
+	mu     sync.Mutex
+	status map[string]*jobStatus // By job name
+}
+
+// jobStatus tracks the runs of a scheduled job
+type jobStatus struct {
+	running bool
+	last    *BackupResult // nil until the job has run
+	lastErr error
+}
+
+// scheduledJob is a job registered with the cron scheduler
+type scheduledJob struct {
+	job config.Job
+	id  cron.EntryID
+}
+
+// cronSpec returns the cron spec for a job's schedule, evaluated in its
+// configured timezone
+func cronSpec(cfg config.BackupConfig) (string, error) {
+	if cfg.Timezone == "" || embeddedTimezone(cfg.Schedule) != "" {
+		return cfg.Schedule, nil
+	}
+	// Checked here for a clearer error than the cron parser gives
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return "", fmt.Errorf("invalid timezone %q: %w", cfg.Timezone, err)
+	}
+	return fmt.Sprintf("CRON_TZ=%s %s", cfg.Timezone, cfg.Schedule), nil
+}
+
+// scheduleTimezone returns the timezone a job's schedule runs in: the one
+// embedded in the schedule with CRON_TZ= or TZ=, which takes precedence, or
+// the configured one. It is "" for local time.
+func scheduleTimezone(cfg config.BackupConfig) string {
+	if tz := embeddedTimezone(cfg.Schedule); tz != "" {
+		return tz
+	}
+	return cfg.Timezone
+}
+
+// embeddedTimezone returns the zone a CRON_TZ= or TZ= prefix of a cron spec
+// names, or "" if it has none
+func embeddedTimezone(spec string) string {
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if rest, ok := strings.CutPrefix(spec, prefix); ok {
+			tz, _, _ := strings.Cut(rest, " ")
+			return tz
+		}
+	}
+	return ""
+}
+
+// printSchedule lists each scheduled job with its next run time
+func printSchedule(c *cron.Cron, scheduled []scheduledJob) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSCHEDULE\tTIMEZONE\tNEXT RUN")
+	for _, s := range scheduled {
+		tz := scheduleTimezone(s.job.Backup)
+		if tz == "" {
+			tz = "Local"
+		}
+		// Entry.Next is filled in asynchronously once the scheduler is
+		// running, so compute the next run from the schedule itself
+		next := c.Entry(s.id).Schedule.Next(time.Now())
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.job.Name, s.job.Backup.Schedule, tz, next.Format("2006-01-02 15:04:05 MST"))
+	}
+	w.Flush()
+}
+
+// overlapWrapper returns the cron wrapper that applies the job's overlap mode
+// to runs within this process
+func overlapWrapper(job config.Job) (cron.JobWrapper, error) {
+	switch job.Backup.Overlap {
+	case "", overlapSkip:
+		return skipIfStillRunning(job.Name), nil
+	case overlapQueue:
+		return queueIfStillRunning(job.Name), nil
+	default:
+		return nil, fmt.Errorf("unsupported overlap mode %q (use %s or %s)", job.Backup.Overlap, overlapSkip, overlapQueue)
+	}
+}
+
+// skipIfStillRunning drops a run if the previous one hasn't finished. Like
+// cron.SkipIfStillRunning, but says which job was skipped.
+func skipIfStillRunning(name string) cron.JobWrapper {
+	return func(j cron.Job) cron.Job {
+		ch := make(chan struct{}, 1)
+		ch <- struct{}{}
+		return cron.FuncJob(func() {
+			select {
+			case v := <-ch:
+				defer func() { ch <- v }()
+				j.Run()
+			default:
+				fmt.Printf("[%s] Backup job %s is still running, skipping this run\n", time.Now().Format(time.RFC3339), name)
+			}
+		})
+	}
+}
+
+// queueIfStillRunning delays a run until the previous one has finished. At
+// most one run is queued, so a job that overruns by several intervals
+// doesn't build up a backlog.
+func queueIfStillRunning(name string) cron.JobWrapper {
+	return func(j cron.Job) cron.Job {
+		var mu sync.Mutex
+		var queued atomic.Bool
+		return cron.FuncJob(func() {
+			if !mu.TryLock() {
+				if !queued.CompareAndSwap(false, true) {
+					fmt.Printf("[%s] Backup job %s is still running and a run is already queued, skipping this run\n", time.Now().Format(time.RFC3339), name)
+					return
+				}
+				fmt.Printf("[%s] Backup job %s is still running, queueing this run\n", time.Now().Format(time.RFC3339), name)
+				mu.Lock()
+				queued.Store(false)
+			}
+			defer mu.Unlock()
+			j.Run()
+		})
+	}
+}
+
+// runScheduledBackup runs one scheduled backup of a job and records it in
+// the metrics. Runs still queued once the scheduler is stopping are dropped.
+func (s *scheduler) runScheduledBackup(ctx context.Context, job config.Job) {
+	select {
+	case <-s.stopping:
+		fmt.Printf("[%s] Scheduler is shutting down, dropping queued run of job %s\n", time.Now().Format(time.RFC3339), job.Name)
+		return
+	default:
+	}
+
+	runningBackups.Add(1)
+	defer runningBackups.Add(-1)
+	s.setRunning(job.Name, true)
+	defer s.setRunning(job.Name, false)
+
+	fmt.Printf("[%s] Running scheduled backup job %s...\n", time.Now().Format(time.RFC3339), job.Name)
+
+	// Another process, such as a manual `backup`, may be running the job
+	result, err := RunBackup(ctx, job)
+	if errors.Is(err, lock.ErrLocked) {
+		if job.Backup.Overlap != overlapQueue {
+			fmt.Printf("[%s] Skipping scheduled run: %v\n", time.Now().Format(time.RFC3339), err)
+			return
+		}
+		fmt.Printf("[%s] Queueing scheduled run: %v\n", time.Now().Format(time.RFC3339), err)
+		for errors.Is(err, lock.ErrLocked) {
+			select {
+			case <-s.stopping:
+				fmt.Printf("[%s] Scheduler is shutting down, dropping queued run of job %s\n", time.Now().Format(time.RFC3339), job.Name)
+				return
+			case <-time.After(lockPollInterval):
+			}
+			result, err = RunBackup(ctx, job)
+		}
+	}
+
+	s.recordRun(job.Name, result, err)
+	s.metrics.Observe(metrics.Run{
+		Job:         job.Name,
+		Success:     err == nil,
+		FailedPhase: result.FailedPhase,
+		Duration:    result.Duration,
+		Phases:      result.Phases,
+		RawSize:     result.RawSize,
+		Size:        result.Size,
+		Stored:      result.Stored,
+		Attempts:    result.Attempts,
+	})
+
+	if err != nil {
+		fmt.Printf("[%s] Scheduled backup job %s failed: %v\n", time.Now().Format(time.RFC3339), job.Name, err)
+		return
+	}
+
+	fmt.Printf("[%s] Scheduled backup job %s completed successfully\n", time.Now().Format(time.RFC3339), job.Name)
+}
+
+// setRunning marks whether a run of the job is in progress
+func (s *scheduler) setRunning(name string, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status[name].running = running
+}
+
+// recordRun keeps the outcome of a finished run for the status endpoint
+func (s *scheduler) recordRun(name string, result *BackupResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status[name].last = result
+	s.status[name].lastErr = err
+}
+
+// schedulerStatus is the body served at /status
+type schedulerStatus struct {
+	Ready bool              `json:"ready"`
+	Jobs  []scheduledStatus `json:"jobs"`
+}
+
+// scheduledStatus describes one scheduled job in /status
+type scheduledStatus struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Timezone string     `json:"timezone,omitempty"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	Running  bool       `json:"running"`
+	LastRun  *runStatus `json:"last_run,omitempty"`
+}
+
+// runStatus describes the outcome of a run in /status
+type runStatus struct {
+	Time        time.Time `json:"time"`
+	Duration    float64   `json:"duration_seconds"`
+	Success     bool      `json:"success"`
+	Artifact    string    `json:"artifact,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	Stored      []string  `json:"stored,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	FailedPhase string    `json:"failed_phase,omitempty"`
+}
+
+// snapshot returns the current state of every scheduled job
+func (s *scheduler) snapshot() schedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ready := s.ready.Load()
+	status := schedulerStatus{Ready: ready, Jobs: make([]scheduledStatus, 0, len(s.jobs))}
+	for _, sj := range s.jobs {
+		js := s.status[sj.job.Name]
+		job := scheduledStatus{
+			Name:     sj.job.Name,
+			Schedule: sj.job.Backup.Schedule,
+			Timezone: scheduleTimezone(sj.job.Backup),
+			Running:  js.running,
+		}
+		// No more runs start once the scheduler is stopping
+		if ready {
+			if next := s.cron.Entry(sj.id).Next; !next.IsZero() {
+				job.NextRun = &next
+			}
+		}
+		if r := js.last; r != nil {
+			job.LastRun = &runStatus{
+				Time:        r.StartTime,
+				Duration:    r.Duration.Seconds(),
+				Success:     js.lastErr == nil,
+				FailedPhase: r.FailedPhase,
+			}
+			if js.lastErr != nil {
+				job.LastRun.Error = js.lastErr.Error()
+			} else {
+				job.LastRun.Artifact = r.Artifact
+				job.LastRun.Size = r.Size
+				job.LastRun.Stored = r.Stored
+			}
+		}
+		status.Jobs = append(status.Jobs, job)
+	}
+	return status
+}
+
+// handler serves the scheduler's HTTP endpoints: /healthz answers while the
+// process is up, /readyz while it is starting runs, /status describes each
+// job and /metrics serves the Prometheus metrics
+func (s *scheduler) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			http.Error(w, "scheduler is not running", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s.snapshot())
+	})
+	mux.Handle("GET /metrics", s.metrics.Handler())
+	return mux
+}
+
+// serveHTTP starts serving the scheduler's endpoints on the configured
+// address in the background. It returns nil if no address is configured.
+func serveHTTP(cfg config.HTTPConfig, s *scheduler) (*http.Server, error) {
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+
+	// Listen up front so that a bad or busy address stops the scheduler
+	// from starting
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Addr: ln.Addr().String(), Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Error serving HTTP: %v\n", err)
+		}
+	}()
+	return server, nil
 }
 
 func init() {
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+	"github.com/saurabhdhingra/backyard-backup/internal/metrics"
+)
+
+// newTestScheduler returns a started scheduler with the given jobs, which
+// never run during the test
+func newTestScheduler(t *testing.T, jobs ...config.Job) *scheduler {
+	t.Helper()
+	c := cron.New()
+	s := &scheduler{cron: c, stopping: make(chan struct{}), metrics: metrics.New(), status: make(map[string]*jobStatus)}
+	for _, job := range jobs {
+		spec, err := cronSpec(job.Backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := c.AddFunc(spec, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.jobs = append(s.jobs, scheduledJob{job: job, id: id})
+		s.status[job.Name] = &jobStatus{}
+		s.metrics.AddJob(job.Name)
+	}
+	c.Start()
+	t.Cleanup(func() { c.Stop() })
+	return s
+}
+
+// get serves a GET request for path from the scheduler's handler
+func get(s *scheduler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestSchedulerHealth(t *testing.T) {
+	s := newTestScheduler(t, config.Job{Name: "app", Backup: config.BackupConfig{Schedule: "@daily"}})
+
+	tests := []struct {
+		name       string
+		ready      bool
+		path       string
+		wantStatus int
+	}{
+		{name: "alive while starting", path: "/healthz", wantStatus: http.StatusOK},
+		{name: "not ready while starting", path: "/readyz", wantStatus: http.StatusServiceUnavailable},
+		{name: "ready", ready: true, path: "/readyz", wantStatus: http.StatusOK},
+		{name: "alive while running", ready: true, path: "/healthz", wantStatus: http.StatusOK},
+		{name: "metrics", ready: true, path: "/metrics", wantStatus: http.StatusOK},
+		{name: "unknown path", ready: true, path: "/nope", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.ready.Store(tt.ready)
+			if rec := get(s, tt.path); rec.Code != tt.wantStatus {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	s.ready.Store(true)
+	if body := get(s, "/metrics").Body.String(); !strings.Contains(body, `backyard_backup_runs_total{job="app",result="success"} 0`) {
+		t.Errorf("/metrics doesn't list the scheduled job:\n%s", body)
+	}
+}
+
+func TestSchedulerStatus(t *testing.T) {
+	s := newTestScheduler(t,
+		config.Job{Name: "orders", Backup: config.BackupConfig{Schedule: "@hourly", Timezone: "Europe/Berlin"}},
+		config.Job{Name: "users", Backup: config.BackupConfig{Schedule: "@daily"}},
+		config.Job{Name: "idle", Backup: config.BackupConfig{Schedule: "@weekly"}},
+	)
+	started := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	s.recordRun("orders", &BackupResult{StartTime: started, Duration: 90 * time.Second, Artifact: "orders_20240301_020000.sql.gz", Size: 1024, Stored: []string{"local"}}, nil)
+	s.recordRun("users", &BackupResult{StartTime: started, Duration: time.Second, FailedPhase: phaseDump}, errors.New("dumping database: connection refused"))
+	s.setRunning("users", true)
+
+	var status schedulerStatus
+	decode := func() {
+		t.Helper()
+		rec := get(s, "/status")
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("GET /status = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		status = schedulerStatus{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.ready.Store(true)
+	decode()
+	if !status.Ready || len(status.Jobs) != 3 {
+		t.Fatalf("status = %+v, want 3 jobs and ready", status)
+	}
+
+	orders, users, idle := status.Jobs[0], status.Jobs[1], status.Jobs[2]
+	if orders.Name != "orders" || orders.Schedule != "@hourly" || orders.Timezone != "Europe/Berlin" || orders.Running {
+		t.Errorf("orders = %+v", orders)
+	}
+	if orders.NextRun == nil || !orders.NextRun.After(time.Now()) {
+		t.Errorf("orders next run = %v, want one in the future", orders.NextRun)
+	}
+	if r := orders.LastRun; r == nil || !r.Success || !r.Time.Equal(started) || r.Duration != 90 || r.Artifact == "" || r.Size != 1024 || len(r.Stored) != 1 || r.Error != "" {
+		t.Errorf("orders last run = %+v, want the successful run", r)
+	}
+	if r := users.LastRun; !users.Running || r == nil || r.Success || r.Error == "" || r.FailedPhase != phaseDump || r.Artifact != "" {
+		t.Errorf("users = %+v, last run %+v, want running after a failed dump", users, r)
+	}
+	if idle.LastRun != nil {
+		t.Errorf("idle last run = %+v, want none", idle.LastRun)
+	}
+
+	// A stopping scheduler starts no more runs
+	s.ready.Store(false)
+	decode()
+	if status.Ready || status.Jobs[0].NextRun != nil {
+		t.Errorf("status while stopping = %+v, want not ready without next runs", status)
+	}
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.BackupConfig
+		want string
+	}{
+		{name: "local", cfg: config.BackupConfig{Schedule: "@daily"}, want: ""},
+		{name: "configured", cfg: config.BackupConfig{Schedule: "0 2 * * *", Timezone: "Asia/Tokyo"}, want: "Asia/Tokyo"},
+		{name: "CRON_TZ", cfg: config.BackupConfig{Schedule: "CRON_TZ=Europe/Berlin 0 2 * * *"}, want: "Europe/Berlin"},
+		{name: "TZ", cfg: config.BackupConfig{Schedule: "TZ=America/New_York @daily"}, want: "America/New_York"},
+		{name: "embedded wins", cfg: config.BackupConfig{Schedule: "CRON_TZ=UTC @hourly", Timezone: "Asia/Tokyo"}, want: "UTC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scheduleTimezone(tt.cfg); got != tt.want {
+				t.Errorf("scheduleTimezone() = %q, want %q", got, tt.want)
+			}
+			// The zone shown is the one the schedule runs in
+			spec, err := cronSpec(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := embeddedTimezone(spec); got != tt.want {
+				t.Errorf("cronSpec() = %q runs in %q, want %q", spec, got, tt.want)
+			}
+		})
+	}
+}
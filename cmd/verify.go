@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+	"github.com/saurabhdhingra/backyard-backup/internal/db"
+	"github.com/saurabhdhingra/backyard-backup/internal/notify"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <backup-id>",
+	Short: "Test-restore a backup into a scratch database and sanity check it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		job, err := selectJob()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		ctx, stop := signalContext()
+		defer stop()
+		if err := RunVerify(ctx, job, args[0]); err != nil {
+			fmt.Printf("Verify failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// scratchDropTimeout bounds dropping the scratch database after a cancelled
+// verification
+const scratchDropTimeout = time.Minute
+
+// RunVerify restores a backup of the job into the scratch database, compares
+// its contents with the manifest and reports the result through the notifier
+func RunVerify(ctx context.Context, job config.Job, id string) error {
+	fmt.Printf("Verifying backup %s...\n", id)
+	startTime := time.Now()
+
+	tables, err := verifyBackup(ctx, job, id)
+
+	report := notify.Report{
+		Kind:     notify.KindVerify,
+		Status:   notify.StatusSuccess,
+		Job:      job.Name,
+		Database: databaseLabel(job.Database),
+		Artifact: id,
+		Duration: time.Since(startTime),
+		Tables:   tables,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		report.Status = notify.StatusFailure
+		report.Error = err.Error()
+	} else {
+		fmt.Printf("Backup %s verified successfully in %s (%d tables checked)\n", id, report.Duration, tables)
+	}
+
+	sendNotification(ctx, job.Notify, report)
+
+	return err
+}
+
+// verifyBackup performs the test restore and returns the number of tables checked
+func verifyBackup(ctx context.Context, job config.Job, id string) (int, error) {
+	t, err := selectTarget(job)
+	if err != nil {
+		return 0, fmt.Errorf("initializing storage: %w", err)
+	}
+
+	entry, err := catalog.New(t.Store).Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "backyard-verify")
+	if err != nil {
+		return 0, fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	restorePath, err := fetchBackup(ctx, t.Store, entry.Artifact.Key, entry.Manifest, job.Backup.Encryption, tmpDir)
+	if err != nil {
+		return 0, err
+	}
+
+	scratchConfig, err := verifyScratchConfig(job, entry, tmpDir)
+	if err != nil {
+		return 0, err
+	}
+	database, err := db.NewDatabase(scratchConfig)
+	if err != nil {
+		return 0, fmt.Errorf("initializing scratch database: %w", err)
+	}
+	scratch, ok := database.(db.Scratch)
+	if !ok {
+		return 0, fmt.Errorf("%s databases can't be used as a scratch target", scratchConfig.Type)
+	}
+
+	fmt.Printf("Creating scratch database %s...\n", scratchConfig.DBName)
+	if err := scratch.CreateScratch(ctx); err != nil {
+		return 0, fmt.Errorf("creating scratch database: %w", err)
+	}
+	// Registered first so it runs after the connection below is closed. The
+	// scratch database is dropped even if verification was cancelled.
+	defer func() {
+		fmt.Println("Dropping scratch database...")
+		dropCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), scratchDropTimeout)
+		defer cancel()
+		if err := scratch.DropScratch(dropCtx); err != nil {
+			fmt.Printf("Warning: failed to drop scratch database: %v\n", err)
+		}
+	}()
+
+	fmt.Println("Restoring into scratch database...")
+	if err := database.Restore(ctx, restorePath); err != nil {
+		return 0, fmt.Errorf("restoring backup: %w", err)
+	}
+
+	if err := database.Connect(ctx); err != nil {
+		return 0, fmt.Errorf("connecting to scratch database: %w", err)
+	}
+	defer database.Close()
+
+	inspector, ok := database.(db.Inspector)
+	if !ok {
+		fmt.Println("Warning: database can't report table counts, only the restore was checked")
+		return 0, nil
+	}
+
+	fmt.Println("Checking restored tables...")
+	counts, err := inspector.TableCounts(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("counting restored rows: %w", err)
+	}
+
+	var recorded map[string]int64
+	if entry.Manifest != nil {
+		recorded = entry.Manifest.TableCounts
+	}
+	if err := compareTableCounts(recorded, counts, AppConfig.Verify.RowCountTolerance); err != nil {
+		return 0, err
+	}
+
+	return len(counts), nil
+}
+
+// verifyScratchConfig returns the database a backup is test-restored into:
+// the configured scratch target, or a temp file for SQLite backups
+func verifyScratchConfig(job config.Job, entry *catalog.Entry, tmpDir string) (db.Config, error) {
+	dbType, sourceDBName := job.Database.Type, job.Database.DBName
+	if entry.Manifest != nil {
+		dbType, sourceDBName = entry.Manifest.DatabaseType, entry.Manifest.DBName
+	}
+
+	dbType, _ = db.CanonicalType(dbType)
+
+	// mongorestore puts an all-databases archive back under its original
+	// names, which would write to those databases on the scratch server
+	// rather than into the scratch database
+	if dbType == "mongodb" && sourceDBName == "" {
+		return db.Config{}, fmt.Errorf("backups of every MongoDB database can't be verified; set dbname on the job to back up a single database")
+	}
+
+	scratch := AppConfig.Verify.Database
+	if scratch.Type == "" {
+		if dbType != "sqlite" {
+			return db.Config{}, fmt.Errorf("no scratch database configured under verify.database for %s backups", dbType)
+		}
+		return db.Config{Type: dbType, DBName: filepath.Join(tmpDir, "verify.db")}, nil
+	}
+
+	if scratchType, _ := db.CanonicalType(scratch.Type); scratchType != dbType {
+		return db.Config{}, fmt.Errorf("scratch database type %s doesn't match backup type %s", scratch.Type, dbType)
+	}
+	if scratch.DBName == "" {
+		return db.Config{}, fmt.Errorf("verify.database.dbname is required")
+	}
+
+	// The scratch database is dropped afterwards and a restore may touch
+	// more than the database it names, so keep clear of every server a job
+	// backs up
+	jobs, err := AppConfig.ResolveJobs()
+	if err != nil {
+		return db.Config{}, err
+	}
+	for _, j := range jobs {
+		if serverAddress(scratch) == serverAddress(j.Database) {
+			return db.Config{}, fmt.Errorf("verify.database must not be on the same server as the database of job %s", j.Name)
+		}
+	}
+
+	cfg := databaseConfig(scratch)
+	cfg.SourceDBName = sourceDBName
+	return cfg, nil
+}
+
+// defaultPorts are the ports database servers listen on unless configured
+// otherwise
+var defaultPorts = map[string]string{
+	"postgres": "5432",
+	"mysql":    "3306",
+	"mongodb":  "27017",
+}
+
+// serverAddress returns the server a database config connects to as
+// host:port, so configs can be compared however they spell it. For SQLite
+// it is the database file. DSNs that are neither URLs nor key=value lists
+// are returned as they are.
+func serverAddress(cfg config.DatabaseConfig) string {
+	dbType, _ := db.CanonicalType(cfg.Type)
+	if dbType == "sqlite" {
+		return filepath.Clean(cfg.DBName)
+	}
+
+	host, port := cfg.Host, ""
+	if cfg.Port != 0 {
+		port = strconv.Itoa(cfg.Port)
+	}
+	if cfg.DSN != "" {
+		if u, err := url.Parse(cfg.DSN); err == nil && u.Host != "" {
+			host, port = u.Hostname(), u.Port()
+		} else if strings.Contains(cfg.DSN, "=") {
+			host, port = "", ""
+			for _, field := range strings.Fields(cfg.DSN) {
+				k, v, _ := strings.Cut(field, "=")
+				switch k {
+				case "host":
+					host = v
+				case "port":
+					port = v
+				}
+			}
+		} else {
+			return cfg.DSN
+		}
+	}
+
+	if port == "" {
+		port = defaultPorts[dbType]
+	}
+	switch strings.ToLower(host) {
+	case "", "localhost", "127.0.0.1", "::1":
+		host = "localhost"
+	}
+	return net.JoinHostPort(strings.ToLower(host), port)
+}
+
+// compareTableCounts checks restored row counts against those recorded at
+// dump time, allowing each to differ by the given fraction
+func compareTableCounts(recorded, restored map[string]int64, tolerance float64) error {
+	if len(recorded) == 0 {
+		fmt.Println("No row counts recorded at dump time (see backup.record_counts), checking the restore isn't empty")
+		if len(restored) == 0 {
+			return fmt.Errorf("restored database contains no tables")
+		}
+		return nil
+	}
+
+	var problems []string
+	for table, want := range recorded {
+		got, ok := restored[table]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("table %s is missing", table))
+			continue
+		}
+		if math.Abs(float64(got-want)) > tolerance*float64(want) {
+			problems = append(problems, fmt.Sprintf("table %s has %d rows, expected %d", table, got, want))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("restored data doesn't match the backup: %s", strings.Join(problems, "; "))
+	}
+
+	fmt.Printf("All %d recorded tables match\n", len(recorded))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	addJobFlag(verifyCmd)
+	addStorageFlag(verifyCmd)
+}
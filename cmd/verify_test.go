@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/catalog"
+	"github.com/saurabhdhingra/backyard-backup/internal/config"
+)
+
+func TestServerAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.DatabaseConfig
+		want string
+	}{
+		{name: "host and port", cfg: config.DatabaseConfig{Type: "postgres", Host: "db.internal", Port: 5433}, want: "db.internal:5433"},
+		{name: "default port", cfg: config.DatabaseConfig{Type: "postgresql", Host: "DB.internal"}, want: "db.internal:5432"},
+		{name: "loopback", cfg: config.DatabaseConfig{Type: "mysql", Host: "127.0.0.1", Port: 3306}, want: "localhost:3306"},
+		{name: "no host", cfg: config.DatabaseConfig{Type: "mongo"}, want: "localhost:27017"},
+		{name: "url dsn", cfg: config.DatabaseConfig{Type: "postgres", DSN: "postgres://u:p@db.internal/app?sslmode=require"}, want: "db.internal:5432"},
+		{name: "mongodb uri", cfg: config.DatabaseConfig{Type: "mongodb", DSN: "mongodb://localhost:27018/app"}, want: "localhost:27018"},
+		{name: "key value dsn", cfg: config.DatabaseConfig{Type: "postgres", DSN: "host=db.internal port=6543 dbname=app"}, want: "db.internal:6543"},
+		{name: "other dsn", cfg: config.DatabaseConfig{Type: "mysql", DSN: "u:p@tcp(db:3306)/app"}, want: "u:p@tcp(db:3306)/app"},
+		{name: "sqlite", cfg: config.DatabaseConfig{Type: "sqlite3", DBName: "./data/app.db"}, want: "data/app.db"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serverAddress(tt.cfg); got != tt.want {
+				t.Errorf("serverAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyScratchConfig(t *testing.T) {
+	prod := config.DatabaseConfig{Type: "postgres", Host: "db.internal", Port: 5432, DBName: "app"}
+	mongo := config.DatabaseConfig{Type: "mongodb", Host: "mongo.internal", Port: 27017, DBName: "app"}
+
+	tests := []struct {
+		name     string
+		scratch  config.DatabaseConfig
+		source   config.DatabaseConfig
+		manifest *catalog.Manifest
+		wantErr  string
+	}{
+		{name: "separate server", scratch: config.DatabaseConfig{Type: "postgres", Host: "scratch.internal", Port: 5432, DBName: "verify"}, source: prod},
+		{name: "type alias", scratch: config.DatabaseConfig{Type: "postgresql", Host: "scratch.internal", Port: 5432, DBName: "verify"}, source: prod},
+		{
+			name:     "manifest type alias",
+			scratch:  config.DatabaseConfig{Type: "mongo", Host: "scratch.internal", DBName: "verify"},
+			source:   mongo,
+			manifest: &catalog.Manifest{DatabaseType: "mongodb", DBName: "app"},
+		},
+		{name: "other type", scratch: config.DatabaseConfig{Type: "mysql", Host: "scratch.internal", DBName: "verify"}, source: prod, wantErr: "doesn't match"},
+		{name: "same server, other database", scratch: config.DatabaseConfig{Type: "postgres", Host: "db.internal", DBName: "verify"}, source: prod, wantErr: "same server"},
+		{name: "same server by dsn", scratch: config.DatabaseConfig{Type: "postgres", DSN: "postgres://db.internal:5432/verify", DBName: "verify"}, source: prod, wantErr: "same server"},
+		{name: "no dbname", scratch: config.DatabaseConfig{Type: "postgres", Host: "scratch.internal"}, source: prod, wantErr: "dbname is required"},
+		{
+			name:     "all mongodb databases",
+			scratch:  config.DatabaseConfig{Type: "mongodb", Host: "scratch.internal", DBName: "verify"},
+			source:   mongo,
+			manifest: &catalog.Manifest{DatabaseType: "mongodb"},
+			wantErr:  "every MongoDB database",
+		},
+		{name: "sqlite without scratch", source: config.DatabaseConfig{Type: "sqlite3", DBName: "app.db"}},
+		{name: "postgres without scratch", source: prod, wantErr: "no scratch database"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := AppConfig
+			t.Cleanup(func() { AppConfig = saved })
+			AppConfig = &config.Config{Database: tt.source, Verify: config.VerifyConfig{Database: tt.scratch}}
+
+			job := config.Job{Name: config.DefaultJobName, Database: tt.source}
+			entry := &catalog.Entry{ID: "app_20240301_020000", Manifest: tt.manifest}
+			cfg, err := verifyScratchConfig(job, entry, t.TempDir())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifyScratchConfig() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.DBName == "" {
+				t.Errorf("verifyScratchConfig() = %+v, want a database name", cfg)
+			}
+		})
+	}
+}
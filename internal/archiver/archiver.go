@@ -1,49 +1,192 @@
 package archiver
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
+	"github.com/ulikunitz/xz"
 )
 
-// Compress compresses the source file to the destination file using Gzip
-func Compress(sourcePath, destPath string) error {
-	srcFile, err := os.Open(sourcePath)
-	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err)
+// Compression algorithms. Bzip2 can only be read.
+const (
+	CompressionNone = "none"
+	Gzip            = "gzip"
+	Zstd            = "zstd"
+	XZ              = "xz"
+	LZ4             = "lz4"
+	Bzip2           = "bzip2"
+)
+
+// compressionMagic maps each algorithm to the bytes its streams start with
+var compressionMagic = []struct {
+	algorithm string
+	magic     []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{XZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{LZ4, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{Bzip2, []byte("BZh")},
+}
+
+// xzDictCaps are the dictionary sizes of xz-utils presets 0-9
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// lz4Levels maps levels 1-9 onto lz4 compression levels
+var lz4Levels = []lz4.CompressionLevel{lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+// Extension returns the file extension for an algorithm
+func Extension(algorithm string) string {
+	switch algorithm {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	case XZ:
+		return ".xz"
+	case LZ4:
+		return ".lz4"
+	case Bzip2:
+		return ".bz2"
+	default:
+		return ""
 	}
-	defer srcFile.Close()
+}
 
-	destFile, err := os.Create(destPath)
+// NewWriter wraps w so that everything written to it is compressed with the
+// given algorithm. A level of 0 uses the algorithm's default. Close flushes
+// the compressed stream but does not close w.
+func NewWriter(w io.Writer, algorithm string, level int) (io.WriteCloser, error) {
+	switch algorithm {
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+
+	case Zstd:
+		// Without zero frames an empty input produces no output at all,
+		// which couldn't be detected as zstd on restore
+		opts := []zstd.EOption{zstd.WithZeroFrames(true)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+
+	case XZ:
+		cfg := xz.WriterConfig{}
+		if level != 0 {
+			if level < 0 || level >= len(xzDictCaps) {
+				return nil, fmt.Errorf("xz level must be between 0 and %d", len(xzDictCaps)-1)
+			}
+			cfg.DictCap = xzDictCaps[level]
+		}
+		return cfg.NewWriter(w)
+
+	case LZ4:
+		lw := lz4.NewWriter(w)
+		if level != 0 {
+			if level < 0 || level > len(lz4Levels) {
+				return nil, fmt.Errorf("lz4 level must be between 0 and %d", len(lz4Levels))
+			}
+			if err := lw.Apply(lz4.CompressionLevelOption(lz4Levels[level-1])); err != nil {
+				return nil, err
+			}
+		}
+		return lw, nil
+
+	case Bzip2:
+		return nil, errors.New("bzip2 is only supported for reading")
+
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+}
+
+// DetectCompression peeks at the start of r and reports which compression
+// algorithm, if any, the data uses
+func DetectCompression(r *bufio.Reader) (string, error) {
+	header, err := r.Peek(6)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read header: %w", err)
+	}
+
+	for _, m := range compressionMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.algorithm, nil
+		}
+	}
+	return CompressionNone, nil
+}
+
+// DetectFileCompression reports which compression algorithm the file at path uses
+func DetectFileCompression(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+		return "", fmt.Errorf("failed to open file: %w", err)
 	}
-	defer destFile.Close()
+	defer f.Close()
 
-	gw := gzip.NewWriter(destFile)
-	defer gw.Close()
+	return DetectCompression(bufio.NewReader(f))
+}
 
-	if _, err := io.Copy(gw, srcFile); err != nil {
-		return fmt.Errorf("failed to compress file: %w", err)
+// NewReader returns a reader yielding the decompressed contents of r, with
+// the algorithm detected from its magic bytes
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	algorithm, err := DetectCompression(br)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	switch algorithm {
+	case Gzip:
+		return gzip.NewReader(br)
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case XZ:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case LZ4:
+		return io.NopCloser(lz4.NewReader(br)), nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	default:
+		return nil, errors.New("data is not compressed with a supported algorithm")
+	}
 }
 
-// Decompress decompresses the source file to the destination file using Gzip
-func Decompress(sourcePath, destPath string) error {
+// Decompress decompresses the source file to the destination file, detecting
+// the algorithm from the file's magic bytes
+func Decompress(ctx context.Context, sourcePath, destPath string) error {
 	srcFile, err := os.Open(sourcePath)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer srcFile.Close()
 
-	gr, err := gzip.NewReader(srcFile)
+	cr, err := NewReader(srcFile)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return fmt.Errorf("failed to create decompressing reader: %w", err)
 	}
-	defer gr.Close()
+	defer cr.Close()
 
 	destFile, err := os.Create(destPath)
 	if err != nil {
@@ -51,7 +194,7 @@ func Decompress(sourcePath, destPath string) error {
 	}
 	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, gr); err != nil {
+	if _, err := ctxio.Copy(ctx, destFile, cr); err != nil {
 		return fmt.Errorf("failed to decompress file: %w", err)
 	}
 
@@ -0,0 +1,215 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bzip2Hello is `printf 'hello bzip2\n' | bzip2 -9`, since there is no
+// bzip2 writer to produce one with
+var bzip2Hello = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xab, 0x6b, 0xa1, 0xf1, 0x00, 0x00,
+	0x02, 0xd9, 0x80, 0x00, 0x10, 0x40, 0x00, 0x10, 0x00, 0x12, 0x64, 0xc0, 0x10, 0x20, 0x00, 0x31,
+	0x00, 0xd3, 0x4d, 0x04, 0x00, 0x1e, 0xa3, 0xef, 0x4e, 0x51, 0xa2, 0x07, 0x8b, 0xb9, 0x22, 0x9c,
+	0x28, 0x48, 0x55, 0xb5, 0xd0, 0xf8, 0x80,
+}
+
+// sampleDump is compressible input resembling a SQL dump
+var sampleDump = []byte(strings.Repeat("INSERT INTO users (id, name) VALUES (1, 'alice');\n", 2000))
+
+func compress(t *testing.T, algorithm string, level int, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, algorithm, level)
+	if err != nil {
+		t.Fatalf("NewWriter(%s, %d): %v", algorithm, level, err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decompress(t *testing.T, compressed []byte) []byte {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	return out
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		levels    []int
+	}{
+		{Gzip, []int{0, 1, 9}},
+		{Zstd, []int{0, 1, 3, 19}},
+		{XZ, []int{0, 1, 9}},
+		{LZ4, []int{0, 1, 9}},
+	}
+
+	for _, tt := range tests {
+		for _, level := range tt.levels {
+			for _, plain := range [][]byte{sampleDump, {}} {
+				compressed := compress(t, tt.algorithm, level, plain)
+				if len(plain) > 0 && len(compressed) >= len(plain) {
+					t.Errorf("%s level %d: %d bytes compressed to %d", tt.algorithm, level, len(plain), len(compressed))
+				}
+
+				detected, err := DetectCompression(bufio.NewReader(bytes.NewReader(compressed)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if detected != tt.algorithm {
+					t.Errorf("%s level %d: detected %q", tt.algorithm, level, detected)
+				}
+
+				if got := decompress(t, compressed); !bytes.Equal(got, plain) {
+					t.Errorf("%s level %d: round trip of %d bytes changed the data", tt.algorithm, level, len(plain))
+				}
+			}
+		}
+	}
+}
+
+func TestBzip2Read(t *testing.T) {
+	detected, err := DetectCompression(bufio.NewReader(bytes.NewReader(bzip2Hello)))
+	if err != nil || detected != Bzip2 {
+		t.Fatalf("DetectCompression() = %q, %v, want %q", detected, err, Bzip2)
+	}
+	if got := decompress(t, bzip2Hello); string(got) != "hello bzip2\n" {
+		t.Errorf("decompressed %q", got)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "gzip", data: []byte{0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00}, want: Gzip},
+		{name: "zstd", data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, want: Zstd},
+		{name: "xz", data: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, want: XZ},
+		{name: "lz4", data: []byte{0x04, 0x22, 0x4d, 0x18, 0x64}, want: LZ4},
+		{name: "bzip2", data: []byte("BZh91AY&SY"), want: Bzip2},
+		{name: "plain sql", data: []byte("-- PostgreSQL database dump\n"), want: CompressionNone},
+		{name: "mongodb archive", data: []byte{0x6d, 0xe2, 0x99, 0x81}, want: CompressionNone},
+		{name: "short", data: []byte{0x1f}, want: CompressionNone},
+		{name: "empty", data: nil, want: CompressionNone},
+		{name: "xz prefix only", data: []byte{0xfd, '7', 'z'}, want: CompressionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectCompression(bufio.NewReader(bytes.NewReader(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectCompression() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectCompressionLeavesReader(t *testing.T) {
+	compressed := compress(t, Gzip, 0, sampleDump)
+	br := bufio.NewReader(bytes.NewReader(compressed))
+	if _, err := DetectCompression(br); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, compressed) {
+		t.Error("DetectCompression consumed input")
+	}
+}
+
+func TestNewReaderUncompressed(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("CREATE TABLE t (id int);")); err == nil {
+		t.Fatal("NewReader accepted uncompressed data")
+	}
+}
+
+func TestNewWriterErrors(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		level     int
+	}{
+		{XZ, -1},
+		{XZ, 10},
+		{LZ4, -1},
+		{LZ4, 10},
+		{Gzip, 10},
+		{Bzip2, 0},
+		{"brotli", 0},
+		{CompressionNone, 0},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewWriter(io.Discard, tt.algorithm, tt.level); err == nil {
+			t.Errorf("NewWriter(%q, %d) succeeded, want an error", tt.algorithm, tt.level)
+		}
+	}
+}
+
+func TestExtension(t *testing.T) {
+	tests := map[string]string{
+		Gzip:            ".gz",
+		Zstd:            ".zst",
+		XZ:              ".xz",
+		LZ4:             ".lz4",
+		Bzip2:           ".bz2",
+		CompressionNone: "",
+	}
+	for algorithm, want := range tests {
+		if got := Extension(algorithm); got != want {
+			t.Errorf("Extension(%q) = %q, want %q", algorithm, got, want)
+		}
+	}
+}
+
+func TestDecompressFile(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, algorithm := range []string{Gzip, Zstd, XZ, LZ4} {
+		compressed := filepath.Join(dir, "dump.sql"+Extension(algorithm))
+		if err := os.WriteFile(compressed, compress(t, algorithm, 0, sampleDump), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if detected, err := DetectFileCompression(compressed); err != nil || detected != algorithm {
+			t.Fatalf("DetectFileCompression() = %q, %v, want %q", detected, err, algorithm)
+		}
+
+		restored := filepath.Join(dir, "restored-"+algorithm+".sql")
+		if err := Decompress(context.Background(), compressed, restored); err != nil {
+			t.Fatalf("Decompress(%s): %v", algorithm, err)
+		}
+		got, err := os.ReadFile(restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, sampleDump) {
+			t.Errorf("%s: file round trip changed the data", algorithm)
+		}
+	}
+}
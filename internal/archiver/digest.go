@@ -0,0 +1,62 @@
+package archiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
+)
+
+// Counter is an io.Writer that counts the bytes written to it
+type Counter int64
+
+func (c *Counter) Write(p []byte) (int, error) {
+	*c += Counter(len(p))
+	return len(p), nil
+}
+
+// Digest is an io.Writer that tracks the size and SHA-256 of the bytes written to it
+type Digest struct {
+	size int64
+	hash hash.Hash
+}
+
+// NewDigest returns an empty Digest
+func NewDigest() *Digest {
+	return &Digest{hash: sha256.New()}
+}
+
+func (d *Digest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+// Size returns the number of bytes written
+func (d *Digest) Size() int64 {
+	return d.size
+}
+
+// SHA256 returns the hex encoded SHA-256 of the bytes written
+func (d *Digest) SHA256() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// FileDigest computes the Digest of the file at path
+func FileDigest(ctx context.Context, path string) (*Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	d := NewDigest()
+	if _, err := ctxio.Copy(ctx, d, f); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return d, nil
+}
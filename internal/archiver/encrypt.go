@@ -0,0 +1,415 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encryption formats reported by DetectEncryption and recorded in manifests
+const (
+	EncryptionNone = "none"
+	EncryptionAES  = "aes-256-gcm"
+	EncryptionAge  = "age"
+)
+
+// File format of AES-256-GCM artifacts:
+//
+//	magic | kdf | [scrypt logN] | salt | chunk...
+//
+// Each chunk holds up to gcmChunkSize bytes of plaintext sealed with a key
+// derived from the master key and the per-file salt. The nonce is the chunk
+// counter with a final-chunk flag, so truncated or reordered files fail to
+// decrypt.
+const (
+	gcmMagic     = "BYBKENC1"
+	gcmChunkSize = 64 * 1024
+	gcmSaltSize  = 32
+	gcmKeySize   = 32
+
+	kdfNone   byte = 0 // master key read from a key file
+	kdfScrypt byte = 1 // master key derived from a passphrase
+
+	scryptLogN = 15
+)
+
+// ageMagic is the first line of a binary age file
+const ageMagic = "age-encryption.org/v1\n"
+
+// EncryptionKeys holds the secrets used to encrypt and decrypt artifacts.
+// Encryption uses AgeRecipients if set, otherwise KeyFile or Passphrase.
+type EncryptionKeys struct {
+	KeyFile         string   // 32-byte key, raw or hex/base64 encoded
+	Passphrase      string   // stretched into a key with scrypt
+	AgeRecipients   []string // age public keys (age1...) to encrypt to
+	AgeIdentityFile string   // age identities used to decrypt
+}
+
+// Format returns the encryption format NewEncryptWriter will produce
+func (k EncryptionKeys) Format() string {
+	if len(k.AgeRecipients) > 0 {
+		return EncryptionAge
+	}
+	return EncryptionAES
+}
+
+// Extension returns the file extension appended to encrypted artifacts
+func (k EncryptionKeys) Extension() string {
+	if k.Format() == EncryptionAge {
+		return ".age"
+	}
+	return ".enc"
+}
+
+// NewEncryptWriter wraps w so that everything written to it is encrypted.
+// Close must be called to write the final chunk; it does not close w.
+func NewEncryptWriter(w io.Writer, keys EncryptionKeys) (io.WriteCloser, error) {
+	if len(keys.AgeRecipients) > 0 {
+		var recipients []age.Recipient
+		for _, r := range keys.AgeRecipients {
+			recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipient %q: %w", r, err)
+			}
+			recipients = append(recipients, recipient)
+		}
+		return age.Encrypt(w, recipients...)
+	}
+
+	salt := make([]byte, gcmSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	header := []byte(gcmMagic)
+	var master []byte
+	var err error
+	switch {
+	case keys.KeyFile != "":
+		header = append(header, kdfNone)
+		master, err = ReadKeyFile(keys.KeyFile)
+	case keys.Passphrase != "":
+		header = append(header, kdfScrypt, scryptLogN)
+		master, err = scrypt.Key([]byte(keys.Passphrase), salt, 1<<scryptLogN, 8, 1, gcmKeySize)
+	default:
+		err = errors.New("encryption requires a key file, passphrase or age recipients")
+	}
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+
+	aead, err := newFileAEAD(master, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return &gcmWriter{w: w, aead: aead, buf: make([]byte, 0, gcmChunkSize)}, nil
+}
+
+// NewDecryptReader returns a reader yielding the plaintext of an artifact
+// produced by NewEncryptWriter, detecting the format from its header
+func NewDecryptReader(r io.Reader, keys EncryptionKeys) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	format, err := DetectEncryption(br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case EncryptionAge:
+		if keys.AgeIdentityFile == "" {
+			return nil, errors.New("artifact is age encrypted but no age identity file is configured")
+		}
+		f, err := os.Open(keys.AgeIdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open age identity file: %w", err)
+		}
+		defer f.Close()
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identity file: %w", err)
+		}
+		return age.Decrypt(br, identities...)
+
+	case EncryptionAES:
+		return newGCMReader(br, keys)
+
+	default:
+		return nil, errors.New("artifact is not encrypted")
+	}
+}
+
+// DetectEncryption peeks at the start of r and reports which encryption
+// format, if any, the data uses
+func DetectEncryption(r *bufio.Reader) (string, error) {
+	header, err := r.Peek(len(ageMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte(gcmMagic)):
+		return EncryptionAES, nil
+	case bytes.HasPrefix(header, []byte(ageMagic)):
+		return EncryptionAge, nil
+	default:
+		return EncryptionNone, nil
+	}
+}
+
+// DetectFileEncryption reports which encryption format the file at path uses
+func DetectFileEncryption(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	return DetectEncryption(bufio.NewReader(f))
+}
+
+// Decrypt decrypts the source file to the destination file
+func Decrypt(ctx context.Context, sourcePath, destPath string, keys EncryptionKeys) error {
+	srcFile, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	dr, err := NewDecryptReader(srcFile, keys)
+	if err != nil {
+		return err
+	}
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer destFile.Close()
+
+	if _, err := ctxio.Copy(ctx, destFile, dr); err != nil {
+		return fmt.Errorf("failed to decrypt file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadKeyFile loads a 32-byte key stored raw, hex or base64 encoded
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(data) == gcmKeySize {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == gcmKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == gcmKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s must hold a %d-byte key, raw or hex/base64 encoded", path, gcmKeySize)
+}
+
+// newFileAEAD derives the per-file key from the master key and salt
+func newFileAEAD(master, salt []byte) (cipher.AEAD, error) {
+	fileKey := make([]byte, gcmKeySize)
+	kdf := hkdf.New(sha256.New, master, salt, []byte("backyard-backup aes-256-gcm"))
+	if _, err := io.ReadFull(kdf, fileKey); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// gcmNonce builds the nonce for a chunk: a big-endian counter followed by a
+// flag byte marking the final chunk
+func gcmNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type gcmWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (e *gcmWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so the final
+		// chunk written by Close is never empty unless the input is
+		if len(e.buf) == gcmChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(e.buf[len(e.buf):gcmChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (e *gcmWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *gcmWriter) seal(last bool) error {
+	out := e.aead.Seal(nil, gcmNonce(e.counter, last), e.buf, nil)
+	if _, err := e.w.Write(out); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+type gcmReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func newGCMReader(r *bufio.Reader, keys EncryptionKeys) (*gcmReader, error) {
+	header := make([]byte, len(gcmMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	var master []byte
+	var salt = make([]byte, gcmSaltSize)
+	switch header[len(gcmMagic)] {
+	case kdfNone:
+		if keys.KeyFile == "" {
+			return nil, errors.New("artifact was encrypted with a key file but none is configured")
+		}
+		if _, err := io.ReadFull(r, salt); err != nil {
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		var err error
+		if master, err = ReadKeyFile(keys.KeyFile); err != nil {
+			return nil, err
+		}
+	case kdfScrypt:
+		if keys.Passphrase == "" {
+			return nil, errors.New("artifact was encrypted with a passphrase but none is configured")
+		}
+		logN, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		if logN > 22 {
+			return nil, fmt.Errorf("scrypt work factor 2^%d is too large", logN)
+		}
+		if _, err := io.ReadFull(r, salt); err != nil {
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		if master, err = scrypt.Key([]byte(keys.Passphrase), salt, 1<<logN, 8, 1, gcmKeySize); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown key derivation %d", header[len(gcmMagic)])
+	}
+
+	aead, err := newFileAEAD(master, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcmReader{r: r, aead: aead, chunk: make([]byte, gcmChunkSize+aead.Overhead())}, nil
+}
+
+func (d *gcmReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk
+func (d *gcmReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the last one only if nothing follows it
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+
+	if n < d.aead.Overhead() {
+		return errors.New("encrypted artifact is truncated")
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], gcmNonce(d.counter, last), d.chunk[:n], nil)
+	if err != nil {
+		return errors.New("failed to decrypt artifact: wrong key, or the file is corrupted or truncated")
+	}
+
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
@@ -0,0 +1,302 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// writeFile writes data to name in a temp dir and returns its path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func encrypt(t *testing.T, keys EncryptionKeys, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, keys)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter: %v", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(keys EncryptionKeys, sealed []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(sealed), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// gcmHeaderSize is the size of the header of a key file encrypted artifact
+const gcmHeaderSize = len(gcmMagic) + 1 + gcmSaltSize
+
+// gcmSealedChunk is the size of a full chunk once sealed
+const gcmSealedChunk = gcmChunkSize + 16
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keyFile := writeFile(t, "backup.key", randomBytes(t, gcmKeySize))
+
+	sizes := []int{0, 1, 1000, gcmChunkSize - 1, gcmChunkSize, gcmChunkSize + 1, 3*gcmChunkSize + 17}
+	for _, size := range sizes {
+		plain := randomBytes(t, size)
+		sealed := encrypt(t, EncryptionKeys{KeyFile: keyFile}, plain)
+		// Shorter plaintexts can turn up in the ciphertext by chance
+		if size >= 16 && bytes.Contains(sealed, plain) {
+			t.Fatalf("size %d: ciphertext contains the plaintext", size)
+		}
+		got, err := decrypt(EncryptionKeys{KeyFile: keyFile}, sealed)
+		if err != nil {
+			t.Fatalf("size %d: decrypt: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: round trip changed the data", size)
+		}
+	}
+}
+
+func TestEncryptSmallWrites(t *testing.T) {
+	keys := EncryptionKeys{KeyFile: writeFile(t, "backup.key", randomBytes(t, gcmKeySize))}
+	plain := randomBytes(t, 2*gcmChunkSize+5)
+
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rest := plain; len(rest) > 0; {
+		n := min(len(rest), 777)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decrypt(keys, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("round trip changed the data")
+	}
+}
+
+func TestEncryptPassphrase(t *testing.T) {
+	plain := randomBytes(t, gcmChunkSize+10)
+	sealed := encrypt(t, EncryptionKeys{Passphrase: "correct horse"}, plain)
+
+	got, err := decrypt(EncryptionKeys{Passphrase: "correct horse"}, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("round trip changed the data")
+	}
+
+	if _, err := decrypt(EncryptionKeys{Passphrase: "battery staple"}, sealed); err == nil {
+		t.Fatal("decrypted with the wrong passphrase")
+	}
+	if _, err := decrypt(EncryptionKeys{KeyFile: writeFile(t, "k", randomBytes(t, gcmKeySize))}, sealed); err == nil {
+		t.Fatal("decrypted a passphrase artifact with a key file")
+	}
+}
+
+func TestEncryptAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := writeFile(t, "identity.txt", []byte(identity.String()+"\n"))
+
+	plain := randomBytes(t, 5000)
+	keys := EncryptionKeys{AgeRecipients: []string{identity.Recipient().String()}, AgeIdentityFile: identityFile}
+	sealed := encrypt(t, keys, plain)
+
+	if format, err := DetectEncryption(bufio.NewReader(bytes.NewReader(sealed))); err != nil || format != EncryptionAge {
+		t.Fatalf("DetectEncryption() = %q, %v, want %q", format, err, EncryptionAge)
+	}
+	got, err := decrypt(EncryptionKeys{AgeIdentityFile: identityFile}, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("round trip changed the data")
+	}
+
+	if _, err := decrypt(EncryptionKeys{}, sealed); err == nil {
+		t.Fatal("decrypted an age artifact without an identity")
+	}
+}
+
+func TestEncryptTampering(t *testing.T) {
+	keyFile := writeFile(t, "backup.key", randomBytes(t, gcmKeySize))
+	keys := EncryptionKeys{KeyFile: keyFile}
+
+	// Three full chunks and a short final one
+	plain := randomBytes(t, 3*gcmChunkSize+100)
+	sealed := encrypt(t, keys, plain)
+	if want := gcmHeaderSize + 3*gcmSealedChunk + 100 + 16; len(sealed) != want {
+		t.Fatalf("sealed size %d, want %d", len(sealed), want)
+	}
+	chunk := func(i int) []byte {
+		start := gcmHeaderSize + i*gcmSealedChunk
+		return sealed[start : start+gcmSealedChunk]
+	}
+
+	concat := func(parts ...[]byte) []byte {
+		var out []byte
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+	header := sealed[:gcmHeaderSize]
+	last := sealed[gcmHeaderSize+3*gcmSealedChunk:]
+
+	flipped := bytes.Clone(sealed)
+	flipped[gcmHeaderSize+gcmSealedChunk+10] ^= 1
+
+	otherKey := writeFile(t, "other.key", randomBytes(t, gcmKeySize))
+
+	tests := []struct {
+		name   string
+		keys   EncryptionKeys
+		sealed []byte
+	}{
+		{name: "final chunk dropped", keys: keys, sealed: sealed[:gcmHeaderSize+3*gcmSealedChunk]},
+		{name: "truncated mid chunk", keys: keys, sealed: sealed[:gcmHeaderSize+gcmSealedChunk+500]},
+		{name: "truncated to the header", keys: keys, sealed: header},
+		{name: "truncated header", keys: keys, sealed: sealed[:gcmHeaderSize-5]},
+		{name: "chunks reordered", keys: keys, sealed: concat(header, chunk(1), chunk(0), chunk(2), last)},
+		{name: "chunk repeated", keys: keys, sealed: concat(header, chunk(0), chunk(0), chunk(2), last)},
+		{name: "chunk removed", keys: keys, sealed: concat(header, chunk(0), chunk(2), last)},
+		{name: "bit flipped", keys: keys, sealed: flipped},
+		{name: "wrong key", keys: EncryptionKeys{KeyFile: otherKey}, sealed: sealed},
+		{name: "no key", keys: EncryptionKeys{}, sealed: sealed},
+		{name: "passphrase for a key file artifact", keys: EncryptionKeys{Passphrase: "x"}, sealed: sealed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decrypt(tt.keys, tt.sealed)
+			if err == nil {
+				t.Fatalf("decrypt succeeded with %d bytes of output, want an error", len(got))
+			}
+		})
+	}
+}
+
+func TestNewEncryptWriterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		keys EncryptionKeys
+	}{
+		{name: "no keys", keys: EncryptionKeys{}},
+		{name: "missing key file", keys: EncryptionKeys{KeyFile: filepath.Join(t.TempDir(), "missing")}},
+		{name: "short key", keys: EncryptionKeys{KeyFile: writeFile(t, "short.key", []byte("too short"))}},
+		{name: "bad age recipient", keys: EncryptionKeys{AgeRecipients: []string{"age1nope"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewEncryptWriter(io.Discard, tt.keys); err == nil {
+				t.Fatal("NewEncryptWriter succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	key := randomBytes(t, gcmKeySize)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "raw", data: key},
+		{name: "hex", data: []byte(hex.EncodeToString(key))},
+		{name: "hex with newline", data: []byte(hex.EncodeToString(key) + "\n")},
+		{name: "base64", data: []byte(base64.StdEncoding.EncodeToString(key) + "\n")},
+		{name: "too short", data: key[:16], wantErr: true},
+		{name: "short hex", data: []byte(hex.EncodeToString(key[:16]) + "\n"), wantErr: true},
+		{name: "garbage", data: []byte("not a key at all\n"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadKeyFile(writeFile(t, "backup.key", tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ReadKeyFile succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("ReadKeyFile() = %x, want %x", got, key)
+			}
+		})
+	}
+}
+
+func TestDetectEncryption(t *testing.T) {
+	keyFile := writeFile(t, "backup.key", randomBytes(t, gcmKeySize))
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "aes", data: encrypt(t, EncryptionKeys{KeyFile: keyFile}, []byte("x")), want: EncryptionAES},
+		{name: "plain", data: []byte("CREATE TABLE t (id int);"), want: EncryptionNone},
+		{name: "gzip", data: []byte{0x1f, 0x8b, 0x08, 0x00}, want: EncryptionNone},
+		{name: "empty", data: nil, want: EncryptionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectEncryption(bufio.NewReader(bytes.NewReader(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectEncryption() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/db"
+	"github.com/saurabhdhingra/backyard-backup/internal/storage"
+)
+
+// ErrNotFound is returned when no backup matches the requested ID
+var ErrNotFound = errors.New("backup not found")
+
+// Entry is a backup artifact found in storage along with its manifest
+type Entry struct {
+	ID       string
+	Artifact storage.ObjectInfo
+	Manifest *Manifest // nil for backups taken before manifests were written
+}
+
+// Time returns when the backup was taken. Without a manifest it falls back
+// to the timestamp in the artifact's name, then its modification time.
+func (e Entry) Time() time.Time {
+	if e.Manifest != nil && !e.Manifest.StartTime.IsZero() {
+		return e.Manifest.StartTime
+	}
+	if _, taken, ok := db.ParseDumpName(e.Artifact.Key); ok {
+		return taken
+	}
+	return e.Artifact.ModTime
+}
+
+// IsBackup reports whether the entry is known to be a backup: it has a
+// manifest or a name in the format dumps are written with. Other files that
+// share the storage location are listed but must never be pruned.
+func (e Entry) IsBackup() bool {
+	if e.Manifest != nil {
+		return true
+	}
+	_, _, ok := db.ParseDumpName(e.Artifact.Key)
+	return ok
+}
+
+// Query filters catalog entries; zero fields match everything
+type Query struct {
+	DatabaseType string
+	DBName       string
+	Tag          string
+	Since        time.Time
+	Until        time.Time
+}
+
+// Matches reports whether the entry satisfies the query
+func (q Query) Matches(e Entry) bool {
+	if q.DatabaseType != "" && (e.Manifest == nil || !sameType(e.Manifest.DatabaseType, q.DatabaseType)) {
+		return false
+	}
+	if q.DBName != "" && (e.Manifest == nil || e.Manifest.DBName != q.DBName) {
+		return false
+	}
+	if q.Tag != "" && (e.Manifest == nil || !slices.Contains(e.Manifest.Tags, q.Tag)) {
+		return false
+	}
+	t := e.Time()
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && t.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// sameType reports whether two database types are the same or aliases
+func sameType(a, b string) bool {
+	a, _ = db.CanonicalType(a)
+	b, _ = db.CanonicalType(b)
+	return a == b
+}
+
+// Catalog reads and writes backup manifests in a storage backend
+type Catalog struct {
+	store storage.Storage
+}
+
+// New returns a catalog over the given storage
+func New(store storage.Storage) *Catalog {
+	return &Catalog{store: store}
+}
+
+// WriteManifest stores the manifest next to its artifact, uploaded with opts
+func (c *Catalog) WriteManifest(ctx context.Context, m *Manifest, opts storage.UploadOptions) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := c.store.StreamUpload(ctx, bytes.NewReader(data), ManifestPath(m.Artifact), opts); err != nil {
+		return fmt.Errorf("uploading manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadManifest loads the manifest for an artifact
+func (c *Catalog) ReadManifest(ctx context.Context, artifact string) (*Manifest, error) {
+	var buf bytes.Buffer
+	if err := c.store.StreamDownload(ctx, ManifestPath(artifact), &buf); err != nil {
+		return nil, fmt.Errorf("downloading manifest: %w", err)
+	}
+	return UnmarshalManifest(buf.Bytes())
+}
+
+// Entries returns every backup in storage, newest first
+func (c *Catalog) Entries(ctx context.Context) ([]Entry, error) {
+	objects, err := storage.ListAll(ctx, c.store, "")
+	if err != nil {
+		return nil, fmt.Errorf("listing storage: %w", err)
+	}
+
+	manifests := make(map[string]bool)
+	for _, obj := range objects {
+		if IsManifest(obj.Key) {
+			manifests[obj.Key] = true
+		}
+	}
+
+	var entries []Entry
+	for _, obj := range objects {
+		if IsManifest(obj.Key) {
+			continue
+		}
+
+		entry := Entry{ID: IDFromArtifact(obj.Key), Artifact: obj}
+		if manifests[ManifestPath(obj.Key)] {
+			m, err := c.ReadManifest(ctx, obj.Key)
+			switch {
+			case ctx.Err() != nil:
+				return nil, ctx.Err()
+			case err != nil:
+				// One bad manifest shouldn't hide every other backup; treat
+				// the artifact like one taken before manifests existed.
+				// Stderr keeps `list --output json` parseable.
+				fmt.Fprintf(os.Stderr, "Warning: ignoring manifest for %s: %v\n", obj.Key, err)
+			default:
+				entry.Manifest = m
+				if m.ID != "" {
+					entry.ID = m.ID
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time().After(entries[j].Time())
+	})
+
+	return entries, nil
+}
+
+// Find returns the entries matching the query, newest first
+func (c *Catalog) Find(ctx context.Context, q Query) ([]Entry, error) {
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []Entry
+	for _, e := range entries {
+		if q.Matches(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+// Latest returns the most recent backup
+func (c *Catalog) Latest(ctx context.Context) (*Entry, error) {
+	return c.LatestBefore(ctx, time.Time{})
+}
+
+// LatestBefore returns the most recent backup taken at or before t.
+// A zero t matches every backup.
+func (c *Catalog) LatestBefore(ctx context.Context, t time.Time) (*Entry, error) {
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if t.IsZero() || !e.Time().After(t) {
+			return &e, nil
+		}
+	}
+	if t.IsZero() {
+		return nil, fmt.Errorf("%w: storage is empty", ErrNotFound)
+	}
+	return nil, fmt.Errorf("%w: none taken before %s", ErrNotFound, t.Format(time.RFC3339))
+}
+
+// Get returns the backup with the given ID or artifact name
+func (c *Catalog) Get(ctx context.Context, id string) (*Entry, error) {
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.ID == id || e.Artifact.Key == id {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Delete removes a backup's artifact and its manifest
+func (c *Catalog) Delete(ctx context.Context, e Entry) error {
+	if err := c.store.Delete(ctx, e.Artifact.Key); err != nil {
+		return err
+	}
+	// Also covers a manifest that couldn't be read, so it isn't left behind
+	if err := c.store.Delete(ctx, ManifestPath(e.Artifact.Key)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("deleting manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/storage"
+)
+
+func TestQueryMatches(t *testing.T) {
+	taken := time.Date(2024, 3, 15, 2, 0, 0, 0, time.UTC)
+	withManifest := Entry{
+		ID:       "app_20240315_020000",
+		Artifact: storage.ObjectInfo{Key: "app_20240315_020000.sql.gz"},
+		Manifest: &Manifest{
+			DatabaseType: "postgresql",
+			DBName:       "app",
+			StartTime:    taken,
+			Tags:         []string{"nightly", "prod"},
+		},
+	}
+	legacy := Entry{
+		ID:       "legacy.sql",
+		Artifact: storage.ObjectInfo{Key: "legacy.sql", ModTime: taken},
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		entry Entry
+		want  bool
+	}{
+		{name: "empty query", query: Query{}, entry: withManifest, want: true},
+		{name: "empty query legacy", query: Query{}, entry: legacy, want: true},
+		{name: "type", query: Query{DatabaseType: "postgresql"}, entry: withManifest, want: true},
+		{name: "type alias", query: Query{DatabaseType: "postgres"}, entry: withManifest, want: true},
+		{name: "other type", query: Query{DatabaseType: "mysql"}, entry: withManifest, want: false},
+		{name: "name", query: Query{DBName: "app"}, entry: withManifest, want: true},
+		{name: "other name", query: Query{DBName: "billing"}, entry: withManifest, want: false},
+		{name: "type and name", query: Query{DatabaseType: "postgres", DBName: "app"}, entry: withManifest, want: true},
+		{name: "type and other name", query: Query{DatabaseType: "postgres", DBName: "billing"}, entry: withManifest, want: false},
+		{name: "tag", query: Query{Tag: "prod"}, entry: withManifest, want: true},
+		{name: "other tag", query: Query{Tag: "weekly"}, entry: withManifest, want: false},
+		{name: "since before", query: Query{Since: taken.Add(-time.Hour)}, entry: withManifest, want: true},
+		{name: "since exact", query: Query{Since: taken}, entry: withManifest, want: true},
+		{name: "since after", query: Query{Since: taken.Add(time.Hour)}, entry: withManifest, want: false},
+		{name: "until after", query: Query{Until: taken.Add(time.Hour)}, entry: withManifest, want: true},
+		{name: "until exact", query: Query{Until: taken}, entry: withManifest, want: true},
+		{name: "until before", query: Query{Until: taken.Add(-time.Hour)}, entry: withManifest, want: false},
+		{name: "legacy has no type", query: Query{DatabaseType: "postgres"}, entry: legacy, want: false},
+		{name: "legacy has no name", query: Query{DBName: "app"}, entry: legacy, want: false},
+		{name: "legacy has no tags", query: Query{Tag: "prod"}, entry: legacy, want: false},
+		{name: "legacy by mod time", query: Query{Since: taken.Add(-time.Hour), Until: taken.Add(time.Hour)}, entry: legacy, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.Matches(tt.entry); got != tt.want {
+				t.Errorf("Matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestCatalog returns a catalog over local storage in a temp dir
+func newTestCatalog(t *testing.T) (*Catalog, storage.Storage) {
+	t.Helper()
+	store := storage.NewLocal(storage.Config{Type: "local", BasePath: t.TempDir()})
+	return New(store), store
+}
+
+// putBackup stores an artifact and, if m is not nil, its manifest
+func putBackup(t *testing.T, store storage.Storage, c *Catalog, artifact string, m *Manifest) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.StreamUpload(ctx, strings.NewReader("dump"), artifact, storage.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		m.Artifact = artifact
+		if err := c.WriteManifest(ctx, m, storage.UploadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ids(entries []Entry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestCatalogLatestBefore(t *testing.T) {
+	ctx := context.Background()
+	c, store := newTestCatalog(t)
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 2, 0, 0, 0, time.UTC) }
+	putBackup(t, store, c, "app_20240301_020000.sql.gz", &Manifest{ID: "first", DatabaseType: "postgres", DBName: "app", StartTime: day(1)})
+	putBackup(t, store, c, "app_20240310_020000.sql.gz", &Manifest{ID: "second", DatabaseType: "postgres", DBName: "app", StartTime: day(10)})
+	putBackup(t, store, c, "app_20240320_020000.sql.gz", &Manifest{ID: "third", DatabaseType: "mysql", DBName: "shop", StartTime: day(20)})
+
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(entries), []string{"third", "second", "first"}; !slices.Equal(got, want) {
+		t.Fatalf("Entries() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name    string
+		before  time.Time
+		want    string
+		missing bool
+	}{
+		{name: "latest", before: time.Time{}, want: "third"},
+		{name: "after every backup", before: day(25), want: "third"},
+		{name: "exactly at a backup", before: day(10), want: "second"},
+		{name: "between backups", before: day(15), want: "second"},
+		{name: "just after the first", before: day(1).Add(time.Second), want: "first"},
+		{name: "before every backup", before: day(1).Add(-time.Second), missing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := c.LatestBefore(ctx, tt.before)
+			if tt.missing {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("LatestBefore() error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.ID != tt.want {
+				t.Errorf("LatestBefore() = %s, want %s", e.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogLatestEmpty(t *testing.T) {
+	c, _ := newTestCatalog(t)
+	if _, err := c.Latest(context.Background()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Latest() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCatalogFind(t *testing.T) {
+	ctx := context.Background()
+	c, store := newTestCatalog(t)
+
+	putBackup(t, store, c, "app_20240301_020000.sql.gz", &Manifest{ID: "app", DatabaseType: "postgres", DBName: "app", Tags: []string{"nightly"}})
+	putBackup(t, store, c, "shop_20240302_020000.sql.gz", &Manifest{ID: "shop", DatabaseType: "mysql", DBName: "shop"})
+	putBackup(t, store, c, "old_20240303_020000.sql", nil)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "everything", query: Query{}, want: []string{"old_20240303_020000", "shop", "app"}},
+		{name: "by type", query: Query{DatabaseType: "mysql"}, want: []string{"shop"}},
+		{name: "by name", query: Query{DBName: "app"}, want: []string{"app"}},
+		{name: "by tag", query: Query{Tag: "nightly"}, want: []string{"app"}},
+		{name: "no match", query: Query{DBName: "nope"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := c.Find(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("Find() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogUnreadableManifest(t *testing.T) {
+	ctx := context.Background()
+	c, store := newTestCatalog(t)
+
+	putBackup(t, store, c, "app_20240301_020000.sql.gz", &Manifest{ID: "good", DatabaseType: "postgres"})
+	putBackup(t, store, c, "app_20240302_020000.sql.gz", nil)
+	corrupt := ManifestPath("app_20240302_020000.sql.gz")
+	if err := store.StreamUpload(ctx, strings.NewReader("{not json"), corrupt, storage.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		t.Fatalf("Entries() error = %v, want the readable backups", err)
+	}
+	if got, want := ids(entries), []string{"app_20240302_020000", "good"}; !slices.Equal(got, want) {
+		t.Fatalf("Entries() = %v, want %v", got, want)
+	}
+	if entries[0].Manifest != nil {
+		t.Errorf("entry with a corrupt manifest has Manifest %+v, want nil", entries[0].Manifest)
+	}
+
+	// Deleting the backup also removes the manifest that couldn't be read
+	if err := c.Delete(ctx, entries[0]); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Exists(ctx, corrupt); err != nil || ok {
+		t.Errorf("Exists(%s) = %t, %v after Delete, want false", corrupt, ok, err)
+	}
+}
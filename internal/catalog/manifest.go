@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/archiver"
+	"github.com/saurabhdhingra/backyard-backup/internal/db"
+)
+
+// ErrChecksumMismatch is returned when an artifact doesn't match its manifest
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ManifestSuffix is appended to an artifact's name to form its manifest's name
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes a single backup artifact. It is stored as JSON next to
+// the artifact it describes.
+type Manifest struct {
+	ID               string    `json:"id"`
+	Artifact         string    `json:"artifact"`
+	Job              string    `json:"job,omitempty"`
+	DatabaseType     string    `json:"database_type"`
+	EngineVersion    string    `json:"engine_version,omitempty"`
+	DBName           string    `json:"dbname,omitempty"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+	RawSize          int64     `json:"raw_size"`
+	Size             int64     `json:"size"`
+	SHA256           string    `json:"sha256"`
+	Compression      string    `json:"compression"`
+	CompressionLevel int       `json:"compression_level,omitempty"`
+	Encryption       string    `json:"encryption"`
+	ToolVersion      string    `json:"tool_version"`
+	Hostname         string    `json:"hostname,omitempty"`
+	Tags             []string  `json:"tags,omitempty"`
+
+	// TableCounts holds rows per table counted just before the dump started
+	TableCounts map[string]int64 `json:"table_counts,omitempty"`
+}
+
+// IDFromArtifact derives a backup ID from an artifact name by dropping the
+// file extensions after the dump timestamp
+func IDFromArtifact(artifact string) string {
+	if stem, _, ok := db.ParseDumpName(artifact); ok {
+		return stem
+	}
+	return path.Base(artifact)
+}
+
+// ManifestPath returns the storage path of the manifest for an artifact
+func ManifestPath(artifact string) string {
+	return artifact + ManifestSuffix
+}
+
+// IsManifest reports whether a storage path names a manifest file
+func IsManifest(path string) bool {
+	return strings.HasSuffix(path, ManifestSuffix)
+}
+
+// Verify checks that the file at path has the size and SHA-256 recorded in
+// the manifest, returning ErrChecksumMismatch if it doesn't
+func (m *Manifest) Verify(ctx context.Context, path string) error {
+	if m.SHA256 == "" {
+		return fmt.Errorf("manifest for %s has no checksum", m.Artifact)
+	}
+
+	digest, err := archiver.FileDigest(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if digest.Size() != m.Size {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrChecksumMismatch, m.Artifact, digest.Size(), m.Size)
+	}
+	if sum := digest.SHA256(); sum != m.SHA256 {
+		return fmt.Errorf("%w: %s has SHA-256 %s, expected %s", ErrChecksumMismatch, m.Artifact, sum, m.SHA256)
+	}
+
+	return nil
+}
+
+// Marshal encodes the manifest as indented JSON
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling manifest: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// UnmarshalManifest decodes a manifest from JSON
+func UnmarshalManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("unmarshaling manifest: %w", err)
+	}
+	return &m, nil
+}
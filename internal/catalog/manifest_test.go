@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeArtifact writes data to a file in a temp dir and returns its path
+func writeArtifact(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app_20240101_020000.sql.gz")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestManifestVerify(t *testing.T) {
+	const data = "backup contents"
+
+	tests := []struct {
+		name     string
+		manifest Manifest
+		mismatch bool
+		wantErr  bool
+	}{
+		{
+			name:     "matches",
+			manifest: Manifest{Size: int64(len(data)), SHA256: sha256Hex(data)},
+		},
+		{
+			name:     "size mismatch",
+			manifest: Manifest{Size: int64(len(data)) + 1, SHA256: sha256Hex(data)},
+			mismatch: true,
+			wantErr:  true,
+		},
+		{
+			name:     "checksum mismatch",
+			manifest: Manifest{Size: int64(len(data)), SHA256: sha256Hex("backup Contents")},
+			mismatch: true,
+			wantErr:  true,
+		},
+		{
+			name:     "no checksum",
+			manifest: Manifest{Size: int64(len(data))},
+			wantErr:  true,
+		},
+	}
+
+	path := writeArtifact(t, data)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.manifest.Artifact = filepath.Base(path)
+			err := tt.manifest.Verify(context.Background(), path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want error %t", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrChecksumMismatch) != tt.mismatch {
+				t.Errorf("Verify() error = %v, want ErrChecksumMismatch %t", err, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestManifestVerifyMissingFile(t *testing.T) {
+	m := Manifest{Artifact: "gone.sql.gz", Size: 1, SHA256: sha256Hex("x")}
+	err := m.Verify(context.Background(), filepath.Join(t.TempDir(), "gone.sql.gz"))
+	if err == nil || errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Verify() error = %v, want a read error", err)
+	}
+}
+
+func TestManifestVerifyCancelled(t *testing.T) {
+	const data = "backup contents"
+	m := Manifest{Artifact: "app.sql.gz", Size: int64(len(data)), SHA256: sha256Hex(data)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Verify(ctx, writeArtifact(t, data)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Verify() error = %v, want context.Canceled", err)
+	}
+}
@@ -1,22 +1,82 @@
 package config
 
 import (
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Database DatabaseConfig `mapstructure:"database"`
-	Storage  StorageConfig  `mapstructure:"storage"`
-	Backup   BackupConfig   `mapstructure:"backup"`
-	Log      LogConfig      `mapstructure:"log"`
-	Notify   NotifyConfig   `mapstructure:"notify"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	Storage   StorageConfig   `mapstructure:"storage"`
+	Backup    BackupConfig    `mapstructure:"backup"`
+	Retention RetentionConfig `mapstructure:"retention"`
+	Verify    VerifyConfig    `mapstructure:"verify"`
+	Log       LogConfig       `mapstructure:"log"`
+	Notify    NotifyConfig    `mapstructure:"notify"`
+	Heartbeat HeartbeatConfig `mapstructure:"heartbeat"`
+	Retry     RetryConfig     `mapstructure:"retry"`
+	HTTP      HTTPConfig      `mapstructure:"http"`
+
+	// Named databases and storage targets referenced by jobs
+	Databases map[string]DatabaseConfig `mapstructure:"databases"`
+	Storages  map[string]StorageConfig  `mapstructure:"storages"`
+	Jobs      []JobConfig               `mapstructure:"jobs"`
 }
 
-type NotifyConfig struct {
-	SlackWebhook string `mapstructure:"slack_webhook"`
-	Enabled      bool   `mapstructure:"enabled"`
+// NotifyConfig lists the channels run results are sent to. The older
+// single-Slack form, {enabled, slack_webhook}, is still accepted.
+type NotifyConfig []NotifierConfig
+
+// HTTPConfig configures the listener the scheduler serves metrics, health
+// checks and status on
+type HTTPConfig struct {
+	Listen string `mapstructure:"listen"` // Address such as ":9090"; empty disables the listener
+}
+
+// HeartbeatConfig sets the URLs a job pings when a run starts, succeeds and
+// fails, so an external monitor notices when backups stop happening
+type HeartbeatConfig struct {
+	// URL is a healthchecks.io-style check URL, pinged on success and with
+	// /start and /fail appended for the other events
+	URL string `mapstructure:"url"`
+
+	// Per-event URLs for other monitors; each overrides the one derived from URL
+	StartURL   string `mapstructure:"start_url"`
+	SuccessURL string `mapstructure:"success_url"`
+	FailureURL string `mapstructure:"failure_url"`
+}
+
+// NotifierConfig is one notification channel
+type NotifierConfig struct {
+	Type string `mapstructure:"type"` // slack, webhook, email, teams, discord or ntfy
+	Name string `mapstructure:"name"` // Used in log messages; defaults to the type
+	URL  string `mapstructure:"url"`  // Webhook URL, or the topic URL for ntfy
+
+	// text/template sources for the message title and text, executed with
+	// the run's report; empty uses the defaults
+	TitleTemplate string `mapstructure:"title_template"`
+	Template      string `mapstructure:"template"`
+
+	// OnSuccess and OnFailure choose which runs are reported; both default to true
+	OnSuccess *bool `mapstructure:"on_success"`
+	OnFailure *bool `mapstructure:"on_failure"`
+
+	Headers  map[string]string `mapstructure:"headers"`  // Extra HTTP headers for webhook
+	Token    string            `mapstructure:"token"`    // ntfy access token
+	Priority string            `mapstructure:"priority"` // ntfy priority, e.g. high
+
+	// SMTP settings for email
+	SMTPHost string   `mapstructure:"smtp_host"`
+	SMTPPort int      `mapstructure:"smtp_port"` // Default 587; 465 uses implicit TLS
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
 }
 
 type DatabaseConfig struct {
@@ -30,19 +90,136 @@ type DatabaseConfig struct {
 }
 
 type StorageConfig struct {
-	Type      string `mapstructure:"type"`
-	Path      string `mapstructure:"path"`   // For local
-	Bucket    string `mapstructure:"bucket"` // For cloud
-	Region    string `mapstructure:"region"`
-	AccessKey string `mapstructure:"access_key"`
-	SecretKey string `mapstructure:"secret_key"`
+	Type         string `mapstructure:"type"`
+	Path         string `mapstructure:"path"`   // For local
+	Bucket       string `mapstructure:"bucket"` // For cloud
+	Region       string `mapstructure:"region"`
+	AccessKey    string `mapstructure:"access_key"`
+	SecretKey    string `mapstructure:"secret_key"`
+	SessionToken string `mapstructure:"session_token"` // For temporary S3 credentials
+
+	// For GCS; empty uses Application Default Credentials, such as workload identity
+	CredentialsFile string `mapstructure:"credentials_file"` // Service account key JSON
+
+	// For Azure Blob Storage, where bucket names the container. One of
+	// connection_string, account_key or sas_token authenticates.
+	AccountName      string `mapstructure:"account_name"`
+	AccountKey       string `mapstructure:"account_key"`
+	SASToken         string `mapstructure:"sas_token"`
+	ConnectionString string `mapstructure:"connection_string"`
+
+	// For S3-compatible services such as MinIO, Ceph, R2 and Wasabi
+	Endpoint           string `mapstructure:"endpoint"`             // e.g. "https://minio.internal:9000"
+	PathStyle          bool   `mapstructure:"path_style"`           // Address buckets as endpoint/bucket rather than bucket.endpoint
+	CABundle           string `mapstructure:"ca_bundle"`            // PEM file of the CAs to trust instead of the system ones
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"` // Don't verify the endpoint's certificate
+
+	// S3 server-side encryption: AES256, aws:kms or SSE-C
+	SSE                string `mapstructure:"sse"`
+	KMSKeyID           string `mapstructure:"kms_key_id"`            // For aws:kms, or a Cloud KMS key name for gcs; empty uses the managed key
+	SSECustomerKeyFile string `mapstructure:"sse_customer_key_file"` // For SSE-C: 32-byte key, raw or hex/base64 encoded
+
+	StorageClass string            `mapstructure:"storage_class"` // e.g. STANDARD_IA or GLACIER_IR
+	ObjectTags   map[string]string `mapstructure:"object_tags"`   // Added to every object, e.g. a retention tier for lifecycle rules
+
+	// Immutable backups: S3 Object Lock, or write-once files for local
+	// storage. Backups are locked for LockPeriod, which defaults to a
+	// period derived from the job's retention policy.
+	ObjectLock ObjectLockConfig `mapstructure:"object_lock"`
+	WORM       bool             `mapstructure:"worm"`
+	LockPeriod string           `mapstructure:"lock_period"` // e.g. "30d"
+}
+
+// ObjectLockConfig sets the S3 Object Lock applied to uploads. The bucket
+// must have Object Lock enabled.
+type ObjectLockConfig struct {
+	Mode      string `mapstructure:"mode"`       // "governance" or "compliance"; empty disables retention
+	LegalHold bool   `mapstructure:"legal_hold"` // Also place a legal hold, which lasts until removed
 }
 
 type BackupConfig struct {
-	Schedule    string `mapstructure:"schedule"`
-	Compression bool   `mapstructure:"compression"`
+	Schedule    string            `mapstructure:"schedule"`
+	Timezone    string            `mapstructure:"timezone"` // IANA name the schedule is evaluated in, e.g. "Europe/Berlin"; defaults to local time
+	Compression CompressionConfig `mapstructure:"compression"`
+	Tags        []string          `mapstructure:"tags"` // Recorded in each manifest, for filtering with `list --tag`
+
+	// RecordCounts stores per-table row counts in the manifest so `verify`
+	// can compare them. Counting every table can be slow on large databases.
+	RecordCounts bool `mapstructure:"record_counts"`
+
+	Encryption EncryptionConfig `mapstructure:"encryption"`
+
+	// Overlap decides what the scheduler does when a job is due while its
+	// previous run is still going: "skip" the new run (default) or "queue" it
+	Overlap string `mapstructure:"overlap"`
+	LockDir string `mapstructure:"lock_dir"` // Where job lock files are kept; defaults to the system temp dir
+
+	// Timeout bounds a whole backup run, including retries. Dump processes
+	// still running when it expires are terminated. Zero means no limit.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// CompressionConfig selects how artifacts are compressed. In YAML it may also
+// be given as a bool (true for gzip) or just the algorithm name.
+type CompressionConfig struct {
+	Algorithm string `mapstructure:"algorithm"` // gzip, zstd, xz, lz4 or none
+	Level     int    `mapstructure:"level"`     // 0 uses the algorithm's default
 }
 
+// Enabled reports whether artifacts are compressed
+func (c CompressionConfig) Enabled() bool {
+	return c.Algorithm != "" && c.Algorithm != "none"
+}
+
+// EncryptionConfig enables client-side encryption of backup artifacts.
+// Artifacts are encrypted to AgeRecipients if set, otherwise with AES-256-GCM
+// using KeyFile or a key derived from Passphrase. Restores detect the format
+// and need KeyFile, Passphrase or AgeIdentityFile respectively.
+type EncryptionConfig struct {
+	Enabled         bool     `mapstructure:"enabled"`
+	KeyFile         string   `mapstructure:"key_file"`
+	Passphrase      string   `mapstructure:"passphrase"`
+	AgeRecipients   []string `mapstructure:"age_recipients"`
+	AgeIdentityFile string   `mapstructure:"age_identity_file"`
+}
+
+type RetentionConfig struct {
+	KeepLast    int    `mapstructure:"keep_last"`
+	KeepDaily   int    `mapstructure:"keep_daily"`
+	KeepWeekly  int    `mapstructure:"keep_weekly"`
+	KeepMonthly int    `mapstructure:"keep_monthly"`
+	KeepYearly  int    `mapstructure:"keep_yearly"`
+	MaxAge      string `mapstructure:"max_age"` // e.g. "720h", "30d", "12w"
+}
+
+// RetryConfig controls retries of the connect, dump and upload steps of a
+// backup after transient failures
+type RetryConfig struct {
+	MaxAttempts    int           `mapstructure:"max_attempts"` // Total attempts per step; 0 or 1 disables retries
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	Multiplier     float64       `mapstructure:"multiplier"`
+	Jitter         float64       `mapstructure:"jitter"`   // Fraction of each wait randomized, e.g. 0.2
+	RetryOn        []string      `mapstructure:"retry_on"` // Error classes to retry; empty retries all of them
+}
+
+type VerifyConfig struct {
+	// Database is the scratch target backups are test-restored into. It is
+	// created and dropped by every verify run, so it must not hold real data.
+	// May be omitted for SQLite, which uses a temp file.
+	Database DatabaseConfig `mapstructure:"database"`
+
+	// RowCountTolerance is the fraction a restored table's row count may
+	// differ from the count recorded at dump time, e.g. 0.01 for 1%. Counts
+	// are taken just before the dump rather than in its snapshot, so rows
+	// written in between show up as a difference.
+	RowCountTolerance float64 `mapstructure:"row_count_tolerance"`
+}
+
+// DefaultRowCountTolerance absorbs the writes a live database takes between
+// counting rows and starting the dump
+const DefaultRowCountTolerance = 0.05
+
 type LogConfig struct {
 	Level string `mapstructure:"level"`
 	File  string `mapstructure:"file"`
@@ -62,14 +239,73 @@ func LoadConfig(cfgFile string) (*Config, error) {
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
+	// AutomaticEnv only applies to keys present in the config file, but the
+	// passphrase is usually supplied through the environment alone
+	viper.BindEnv("backup.encryption.passphrase")
+
+	viper.SetDefault("verify.row_count_tolerance", DefaultRowCountTolerance)
+
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
 	}
 
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	hooks := mapstructure.ComposeDecodeHookFunc(
+		compressionHook,
+		notifyHook,
+		// viper's defaults, which a custom DecodeHook replaces
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)
+	if err := viper.Unmarshal(&config, viper.DecodeHook(hooks)); err != nil {
 		return nil, err
 	}
 
 	return &config, nil
 }
+
+// compressionHook accepts the shorthand forms of a compression block:
+// a bool, as in older configs, or a bare algorithm name
+func compressionHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(CompressionConfig{}) {
+		return data, nil
+	}
+
+	switch v := data.(type) {
+	case bool:
+		if v {
+			return map[string]interface{}{"algorithm": "gzip"}, nil
+		}
+		return map[string]interface{}{"algorithm": "none"}, nil
+	case string:
+		// Environment overrides arrive as strings, so "true" is still a bool
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			return compressionHook(from, to, enabled)
+		}
+		return map[string]interface{}{"algorithm": v}, nil
+	default:
+		return data, nil
+	}
+}
+
+// notifyHook converts the older notify block, a map with enabled and
+// slack_webhook, into a channel list
+func notifyHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	legacy, ok := data.(map[string]interface{})
+	if to != reflect.TypeOf(NotifyConfig{}) || !ok {
+		return data, nil
+	}
+
+	enabled := false
+	switch v := legacy["enabled"].(type) {
+	case bool:
+		enabled = v
+	case string:
+		enabled, _ = strconv.ParseBool(v)
+	}
+	webhook, _ := legacy["slack_webhook"].(string)
+	if !enabled || webhook == "" {
+		return []interface{}{}, nil
+	}
+	return []interface{}{map[string]interface{}{"type": "slack", "url": webhook}}, nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// loadYAML loads a config file with the given contents. LoadConfig sets up
+// the global viper instance, so it is reset around each load.
+func loadYAML(t *testing.T, contents string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return c
+}
+
+func TestCompressionShapes(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  string // BACKUP_BACKUP_COMPRESSION, if set
+		want CompressionConfig
+	}{
+		{name: "unset", yaml: "backup: {}", want: CompressionConfig{}},
+		{name: "true", yaml: "backup:\n  compression: true", want: CompressionConfig{Algorithm: "gzip"}},
+		{name: "false", yaml: "backup:\n  compression: false", want: CompressionConfig{Algorithm: "none"}},
+		{name: "algorithm name", yaml: "backup:\n  compression: zstd", want: CompressionConfig{Algorithm: "zstd"}},
+		{name: "block", yaml: "backup:\n  compression:\n    algorithm: xz\n    level: 9", want: CompressionConfig{Algorithm: "xz", Level: 9}},
+		{name: "env bool", yaml: "backup:\n  compression: zstd", env: "true", want: CompressionConfig{Algorithm: "gzip"}},
+		{name: "env name", yaml: "backup:\n  compression: true", env: "lz4", want: CompressionConfig{Algorithm: "lz4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("BACKUP_BACKUP_COMPRESSION", tt.env)
+			}
+			c := loadYAML(t, tt.yaml)
+			if c.Backup.Compression != tt.want {
+				t.Errorf("compression = %+v, want %+v", c.Backup.Compression, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobCompressionShapes(t *testing.T) {
+	c := loadYAML(t, `
+jobs:
+  - name: old
+    compression: true
+  - name: named
+    compression: zstd
+  - name: inherited
+`)
+	want := []*CompressionConfig{{Algorithm: "gzip"}, {Algorithm: "zstd"}, nil}
+	for i, jc := range c.Jobs {
+		if !reflect.DeepEqual(jc.Compression, want[i]) {
+			t.Errorf("job %s compression = %+v, want %+v", jc.Name, jc.Compression, want[i])
+		}
+	}
+}
+
+func TestNotifyShapes(t *testing.T) {
+	slack := NotifyConfig{{Type: "slack", URL: "https://hooks.slack.com/x"}}
+
+	tests := []struct {
+		name string
+		yaml string
+		want NotifyConfig
+	}{
+		{name: "unset", yaml: "backup: {}"},
+		{name: "legacy enabled", yaml: "notify:\n  enabled: true\n  slack_webhook: https://hooks.slack.com/x", want: slack},
+		{name: "legacy enabled as string", yaml: "notify:\n  enabled: \"true\"\n  slack_webhook: https://hooks.slack.com/x", want: slack},
+		{name: "legacy disabled", yaml: "notify:\n  enabled: false\n  slack_webhook: https://hooks.slack.com/x", want: NotifyConfig{}},
+		{name: "legacy without webhook", yaml: "notify:\n  enabled: true", want: NotifyConfig{}},
+		{
+			name: "channel list",
+			yaml: "notify:\n  - type: slack\n    url: https://hooks.slack.com/x\n  - type: ntfy\n    url: https://ntfy.sh/backups",
+			want: NotifyConfig{slack[0], {Type: "ntfy", URL: "https://ntfy.sh/backups"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadYAML(t, tt.yaml)
+			if !reflect.DeepEqual(c.Notify, tt.want) {
+				t.Errorf("notify = %+v, want %+v", c.Notify, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobNotifyShapes(t *testing.T) {
+	c := loadYAML(t, `
+jobs:
+  - name: old
+    notify:
+      enabled: true
+      slack_webhook: https://hooks.slack.com/x
+  - name: muted
+    notify: []
+  - name: inherited
+`)
+	want := []*NotifyConfig{{{Type: "slack", URL: "https://hooks.slack.com/x"}}, {}, nil}
+	for i, jc := range c.Jobs {
+		if !reflect.DeepEqual(jc.Notify, want[i]) {
+			t.Errorf("job %s notify = %+v, want %+v", jc.Name, jc.Notify, want[i])
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultJobName names the job synthesized from the top-level database and
+// storage blocks when no jobs are configured
+const DefaultJobName = "default"
+
+// JobConfig is one entry of the jobs list. Settings left unset fall back to
+// the top-level block of the same name.
+type JobConfig struct {
+	Name     string   `mapstructure:"name"`
+	Database string   `mapstructure:"database"` // Key in databases; empty uses the top-level database
+	Storage  []string `mapstructure:"storage"`  // Keys in storages; empty uses the top-level storage
+	Schedule string   `mapstructure:"schedule"`
+	Timezone string   `mapstructure:"timezone"`
+	Overlap  string   `mapstructure:"overlap"`
+
+	// Timeout overrides backup.timeout for this job
+	Timeout *time.Duration `mapstructure:"timeout"`
+
+	// Prefix is the sub-path of each storage target the job's backups are
+	// kept under. Defaults to the job name; set it to "" to keep backups
+	// made before jobs were configured in the storage root.
+	Prefix *string `mapstructure:"prefix"`
+
+	Compression  *CompressionConfig `mapstructure:"compression"`
+	Encryption   *EncryptionConfig  `mapstructure:"encryption"`
+	Tags         []string           `mapstructure:"tags"`
+	RecordCounts *bool              `mapstructure:"record_counts"`
+	Retention    *RetentionConfig   `mapstructure:"retention"`
+	Notify       *NotifyConfig      `mapstructure:"notify"`
+	Heartbeat    *HeartbeatConfig   `mapstructure:"heartbeat"`
+	Retry        *RetryConfig       `mapstructure:"retry"`
+}
+
+// StorageTarget is a named storage backend a job writes to
+type StorageTarget struct {
+	Name string
+	StorageConfig
+}
+
+// Job is a backup job with every setting resolved
+type Job struct {
+	Name      string
+	Database  DatabaseConfig
+	Storages  []StorageTarget // The first target is used for restores
+	Prefix    string
+	Backup    BackupConfig
+	Retention RetentionConfig
+	Notify    NotifyConfig
+	Heartbeat HeartbeatConfig
+	Retry     RetryConfig
+}
+
+// ResolveJobs returns the configured jobs with their defaults applied. A
+// config without a jobs list yields a single job named DefaultJobName built
+// from the top-level blocks.
+func (c *Config) ResolveJobs() ([]Job, error) {
+	if len(c.Jobs) == 0 {
+		if err := checkStorage(c.Storage); err != nil {
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+		return []Job{{
+			Name:      DefaultJobName,
+			Database:  c.Database,
+			Storages:  []StorageTarget{{Name: DefaultJobName, StorageConfig: c.Storage}},
+			Backup:    c.Backup,
+			Retention: c.Retention,
+			Notify:    c.Notify,
+			Heartbeat: c.Heartbeat,
+			Retry:     c.Retry,
+		}}, nil
+	}
+
+	jobs := make([]Job, 0, len(c.Jobs))
+	seen := make(map[string]bool, len(c.Jobs))
+	for _, jc := range c.Jobs {
+		if jc.Name == "" {
+			return nil, fmt.Errorf("every job needs a name")
+		}
+		if seen[jc.Name] {
+			return nil, fmt.Errorf("job %q is defined more than once", jc.Name)
+		}
+		seen[jc.Name] = true
+
+		job, err := c.resolveJob(jc)
+		if err != nil {
+			return nil, fmt.Errorf("job %q: %w", jc.Name, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := checkPrefixes(jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// FindJob returns the resolved job with the given name
+func (c *Config) FindJob(name string) (Job, error) {
+	jobs, err := c.ResolveJobs()
+	if err != nil {
+		return Job{}, err
+	}
+
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Name == name {
+			return job, nil
+		}
+		names = append(names, job.Name)
+	}
+	return Job{}, fmt.Errorf("unknown job %q (configured: %s)", name, strings.Join(names, ", "))
+}
+
+func (c *Config) resolveJob(jc JobConfig) (Job, error) {
+	job := Job{
+		Name:      jc.Name,
+		Prefix:    jc.Name,
+		Backup:    c.Backup,
+		Retention: c.Retention,
+		Notify:    c.Notify,
+		Heartbeat: c.Heartbeat,
+		Retry:     c.Retry,
+	}
+
+	if jc.Database == "" {
+		job.Database = c.Database
+	} else {
+		database, ok := c.Databases[jc.Database]
+		if !ok {
+			return Job{}, fmt.Errorf("unknown database %q", jc.Database)
+		}
+		job.Database = database
+	}
+	if job.Database.Type == "" {
+		return Job{}, fmt.Errorf("no database configured")
+	}
+
+	if len(jc.Storage) == 0 {
+		job.Storages = []StorageTarget{{Name: DefaultJobName, StorageConfig: c.Storage}}
+	}
+	for _, name := range jc.Storage {
+		store, ok := c.Storages[name]
+		if !ok {
+			return Job{}, fmt.Errorf("unknown storage %q", name)
+		}
+		job.Storages = append(job.Storages, StorageTarget{Name: name, StorageConfig: store})
+	}
+	for _, t := range job.Storages {
+		if err := checkStorage(t.StorageConfig); err != nil {
+			return Job{}, fmt.Errorf("storage %q: %w", t.Name, err)
+		}
+	}
+
+	if jc.Prefix != nil {
+		job.Prefix = strings.Trim(*jc.Prefix, "/")
+	}
+	if jc.Schedule != "" {
+		job.Backup.Schedule = jc.Schedule
+	}
+	if jc.Timezone != "" {
+		job.Backup.Timezone = jc.Timezone
+	}
+	if jc.Overlap != "" {
+		job.Backup.Overlap = jc.Overlap
+	}
+	if jc.Timeout != nil {
+		job.Backup.Timeout = *jc.Timeout
+	}
+	if jc.Compression != nil {
+		job.Backup.Compression = *jc.Compression
+	}
+	if jc.Encryption != nil {
+		job.Backup.Encryption = *jc.Encryption
+	}
+	if jc.Tags != nil {
+		job.Backup.Tags = jc.Tags
+	}
+	if jc.RecordCounts != nil {
+		job.Backup.RecordCounts = *jc.RecordCounts
+	}
+	if jc.Retention != nil {
+		job.Retention = *jc.Retention
+	}
+	if jc.Notify != nil {
+		job.Notify = *jc.Notify
+	}
+	if jc.Heartbeat != nil {
+		job.Heartbeat = *jc.Heartbeat
+	}
+	if jc.Retry != nil {
+		job.Retry = *jc.Retry
+	}
+
+	return job, nil
+}
+
+// checkStorage rejects settings the storage type can't apply. Uploading
+// without them would leave backups unlocked or untagged with nothing to say
+// so.
+func checkStorage(s StorageConfig) error {
+	s3 := s.Type == "s3" || s.Type == "aws"
+	azure := s.Type == "azure" || s.Type == "azblob"
+	switch {
+	case (s.ObjectLock.Mode != "" || s.ObjectLock.LegalHold) && !s3:
+		return fmt.Errorf("object_lock is only supported by s3 storage; use a retention or immutability policy on the bucket or container instead")
+	case strings.EqualFold(s.ObjectLock.Mode, "compliance") && s.LockPeriod == "":
+		// The derived period is the span of the coarsest retention rule, and
+		// compliance retention can't be shortened, so every backup would be
+		// stuck for as long as a yearly one
+		return fmt.Errorf("object_lock mode compliance needs an explicit lock_period")
+	case s.WORM && s.Type != "local":
+		return fmt.Errorf("worm is only supported by local storage")
+	case len(s.ObjectTags) > 0 && !s3 && !azure:
+		return fmt.Errorf("object_tags is only supported by s3 and azure storage")
+	}
+	return nil
+}
+
+// checkPrefixes rejects jobs whose backups would land in the same directory
+// of a shared storage target, where one job's retention would prune the
+// other's backups
+func checkPrefixes(jobs []Job) error {
+	type placement struct {
+		job    string
+		prefix string
+	}
+	byStorage := make(map[string][]placement)
+	for _, job := range jobs {
+		for _, target := range job.Storages {
+			byStorage[target.Name] = append(byStorage[target.Name], placement{job.Name, job.Prefix})
+		}
+	}
+
+	storages := make([]string, 0, len(byStorage))
+	for name := range byStorage {
+		storages = append(storages, name)
+	}
+	sort.Strings(storages)
+
+	for _, name := range storages {
+		placements := byStorage[name]
+		for i, a := range placements {
+			for _, b := range placements[i+1:] {
+				if a.prefix == b.prefix || nestedPrefix(a.prefix, b.prefix) || nestedPrefix(b.prefix, a.prefix) {
+					return fmt.Errorf("jobs %q and %q overlap in storage %q, give them distinct prefixes", a.job, b.job, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// nestedPrefix reports whether inner is a sub-path of outer
+func nestedPrefix(outer, inner string) bool {
+	return outer == "" || strings.HasPrefix(path.Clean(inner)+"/", path.Clean(outer)+"/")
+}
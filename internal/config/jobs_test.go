@@ -0,0 +1,275 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig has the top-level blocks every job falls back to, and a named
+// database and storage for jobs to pick
+func testConfig(jobs ...JobConfig) *Config {
+	return &Config{
+		Database:  DatabaseConfig{Type: "postgres", DBName: "app"},
+		Storage:   StorageConfig{Type: "local", Path: "/backups"},
+		Backup:    BackupConfig{Schedule: "@daily", Compression: CompressionConfig{Algorithm: "gzip"}, Tags: []string{"nightly"}},
+		Retention: RetentionConfig{KeepLast: 7},
+		Notify:    NotifyConfig{{Type: "slack", URL: "https://hooks.slack.com/x"}},
+		Retry:     RetryConfig{MaxAttempts: 3},
+		Databases: map[string]DatabaseConfig{"orders": {Type: "mysql", DBName: "orders"}},
+		Storages:  map[string]StorageConfig{"offsite": {Type: "s3", Bucket: "offsite"}},
+		Jobs:      jobs,
+	}
+}
+
+func TestResolveJobsDefault(t *testing.T) {
+	c := testConfig()
+
+	jobs, err := c.ResolveJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Job{{
+		Name:      DefaultJobName,
+		Database:  c.Database,
+		Storages:  []StorageTarget{{Name: DefaultJobName, StorageConfig: c.Storage}},
+		Backup:    c.Backup,
+		Retention: c.Retention,
+		Notify:    c.Notify,
+		Retry:     c.Retry,
+	}}
+	// The default job keeps its backups in the storage root, where they were
+	// before jobs existed
+	if !reflect.DeepEqual(jobs, want) {
+		t.Errorf("ResolveJobs() = %+v, want %+v", jobs, want)
+	}
+}
+
+func TestResolveJobsInheritance(t *testing.T) {
+	timeout := time.Hour
+	empty := ""
+	counts := true
+	retention := RetentionConfig{KeepDaily: 30}
+	notify := NotifyConfig{}
+	defaults := testConfig()
+
+	tests := []struct {
+		name  string
+		job   JobConfig
+		check func(t *testing.T, job Job)
+	}{
+		{
+			name: "everything inherited",
+			job:  JobConfig{Name: "app"},
+			check: func(t *testing.T, job Job) {
+				if job.Prefix != "app" {
+					t.Errorf("Prefix = %q, want the job name", job.Prefix)
+				}
+				if !reflect.DeepEqual(job.Database, defaults.Database) {
+					t.Errorf("Database = %+v, want the top-level one", job.Database)
+				}
+				if len(job.Storages) != 1 || job.Storages[0].Name != DefaultJobName || job.Storages[0].Path != "/backups" {
+					t.Errorf("Storages = %+v, want the top-level storage", job.Storages)
+				}
+				if !reflect.DeepEqual(job.Backup, defaults.Backup) || !reflect.DeepEqual(job.Retention, defaults.Retention) ||
+					!reflect.DeepEqual(job.Notify, defaults.Notify) || !reflect.DeepEqual(job.Retry, defaults.Retry) {
+					t.Errorf("job = %+v, want the top-level settings", job)
+				}
+			},
+		},
+		{
+			name: "named database and storages",
+			job:  JobConfig{Name: "orders", Database: "orders", Storage: []string{"offsite"}},
+			check: func(t *testing.T, job Job) {
+				if job.Database.Type != "mysql" || job.Database.DBName != "orders" {
+					t.Errorf("Database = %+v, want the orders database", job.Database)
+				}
+				if len(job.Storages) != 1 || job.Storages[0].Name != "offsite" || job.Storages[0].Bucket != "offsite" {
+					t.Errorf("Storages = %+v, want offsite", job.Storages)
+				}
+			},
+		},
+		{
+			name: "overrides",
+			job: JobConfig{
+				Name:         "app",
+				Schedule:     "@hourly",
+				Timezone:     "Europe/Berlin",
+				Overlap:      "queue",
+				Timeout:      &timeout,
+				Prefix:       &empty,
+				Compression:  &CompressionConfig{Algorithm: "zstd"},
+				Tags:         []string{"hourly"},
+				RecordCounts: &counts,
+				Retention:    &retention,
+				Notify:       &notify,
+			},
+			check: func(t *testing.T, job Job) {
+				want := BackupConfig{
+					Schedule:     "@hourly",
+					Timezone:     "Europe/Berlin",
+					Overlap:      "queue",
+					Timeout:      time.Hour,
+					Compression:  CompressionConfig{Algorithm: "zstd"},
+					Tags:         []string{"hourly"},
+					RecordCounts: true,
+				}
+				if !reflect.DeepEqual(job.Backup, want) {
+					t.Errorf("Backup = %+v, want %+v", job.Backup, want)
+				}
+				if job.Prefix != "" {
+					t.Errorf("Prefix = %q, want the storage root", job.Prefix)
+				}
+				if job.Retention != retention {
+					t.Errorf("Retention = %+v, want %+v", job.Retention, retention)
+				}
+				// An empty list turns the top-level channels off
+				if len(job.Notify) != 0 {
+					t.Errorf("Notify = %+v, want none", job.Notify)
+				}
+				if !reflect.DeepEqual(job.Retry, defaults.Retry) {
+					t.Errorf("Retry = %+v, want the top-level policy", job.Retry)
+				}
+			},
+		},
+		{
+			name: "prefix slashes trimmed",
+			job:  JobConfig{Name: "app", Prefix: func() *string { p := "/teams/app/"; return &p }()},
+			check: func(t *testing.T, job Job) {
+				if job.Prefix != "teams/app" {
+					t.Errorf("Prefix = %q, want teams/app", job.Prefix)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := testConfig(tt.job).ResolveJobs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != 1 || jobs[0].Name != tt.job.Name {
+				t.Fatalf("ResolveJobs() = %+v, want job %s", jobs, tt.job.Name)
+			}
+			tt.check(t, jobs[0])
+		})
+	}
+}
+
+func TestResolveJobsErrors(t *testing.T) {
+	prefix := func(p string) *string { return &p }
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr string
+	}{
+		{name: "unnamed job", config: testConfig(JobConfig{}), wantErr: "needs a name"},
+		{name: "duplicate job", config: testConfig(JobConfig{Name: "app"}, JobConfig{Name: "app", Prefix: prefix("other")}), wantErr: "more than once"},
+		{name: "unknown database", config: testConfig(JobConfig{Name: "app", Database: "missing"}), wantErr: `unknown database "missing"`},
+		{name: "unknown storage", config: testConfig(JobConfig{Name: "app", Storage: []string{"offsite", "missing"}}), wantErr: `unknown storage "missing"`},
+		{name: "no database", config: &Config{Jobs: []JobConfig{{Name: "app"}}}, wantErr: "no database configured"},
+		{
+			name:    "same prefix",
+			config:  testConfig(JobConfig{Name: "app"}, JobConfig{Name: "orders", Database: "orders", Prefix: prefix("app")}),
+			wantErr: `jobs "app" and "orders" overlap in storage "default"`,
+		},
+		{
+			name:    "nested prefix",
+			config:  testConfig(JobConfig{Name: "app", Storage: []string{"offsite"}}, JobConfig{Name: "orders", Storage: []string{"offsite"}, Prefix: prefix("app/orders")}),
+			wantErr: `overlap in storage "offsite"`,
+		},
+		{
+			name:    "storage root",
+			config:  testConfig(JobConfig{Name: "app", Prefix: prefix("")}, JobConfig{Name: "orders"}),
+			wantErr: "overlap",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.config.ResolveJobs()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ResolveJobs() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPrefixes(t *testing.T) {
+	local := []StorageTarget{{Name: "local"}}
+	offsite := []StorageTarget{{Name: "offsite"}}
+
+	tests := []struct {
+		name    string
+		jobs    []Job
+		wantErr bool
+	}{
+		{name: "distinct prefixes", jobs: []Job{{Name: "a", Prefix: "app", Storages: local}, {Name: "b", Prefix: "orders", Storages: local}}},
+		{name: "shared leading text", jobs: []Job{{Name: "a", Prefix: "app", Storages: local}, {Name: "b", Prefix: "apps", Storages: local}}},
+		{name: "same prefix, other storage", jobs: []Job{{Name: "a", Prefix: "app", Storages: local}, {Name: "b", Prefix: "app", Storages: offsite}}},
+		{name: "same prefix", jobs: []Job{{Name: "a", Prefix: "app", Storages: local}, {Name: "b", Prefix: "app", Storages: local}}, wantErr: true},
+		{name: "nested", jobs: []Job{{Name: "a", Prefix: "teams/app", Storages: local}, {Name: "b", Prefix: "teams", Storages: local}}, wantErr: true},
+		{name: "root and prefix", jobs: []Job{{Name: "a", Prefix: "", Storages: local}, {Name: "b", Prefix: "orders", Storages: local}}, wantErr: true},
+		{name: "unclean paths", jobs: []Job{{Name: "a", Prefix: "app/", Storages: local}, {Name: "b", Prefix: "app//db", Storages: local}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPrefixes(tt.jobs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPrefixes() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveJobsStorageOptions(t *testing.T) {
+	db := DatabaseConfig{Type: "postgres", DBName: "app"}
+	tags := map[string]string{"tier": "daily"}
+
+	tests := []struct {
+		name    string
+		storage StorageConfig
+		wantErr string
+	}{
+		{name: "s3 object lock", storage: StorageConfig{Type: "s3", ObjectLock: ObjectLockConfig{Mode: "governance", LegalHold: true}, ObjectTags: tags}},
+		{name: "s3 compliance", storage: StorageConfig{Type: "s3", ObjectLock: ObjectLockConfig{Mode: "COMPLIANCE"}, LockPeriod: "7d"}},
+		{name: "s3 compliance without period", storage: StorageConfig{Type: "s3", ObjectLock: ObjectLockConfig{Mode: "Compliance"}}, wantErr: "lock_period"},
+		{name: "local worm", storage: StorageConfig{Type: "local", WORM: true}},
+		{name: "azure tags", storage: StorageConfig{Type: "azblob", ObjectTags: tags}},
+		{name: "gcs object lock", storage: StorageConfig{Type: "gcs", ObjectLock: ObjectLockConfig{Mode: "compliance"}}, wantErr: "object_lock"},
+		{name: "azure legal hold", storage: StorageConfig{Type: "azure", ObjectLock: ObjectLockConfig{LegalHold: true}}, wantErr: "object_lock"},
+		{name: "local object lock", storage: StorageConfig{Type: "local", ObjectLock: ObjectLockConfig{Mode: "governance"}}, wantErr: "object_lock"},
+		{name: "s3 worm", storage: StorageConfig{Type: "s3", WORM: true}, wantErr: "worm"},
+		{name: "gcs worm", storage: StorageConfig{Type: "gs", WORM: true}, wantErr: "worm"},
+		{name: "gcs tags", storage: StorageConfig{Type: "gcs", ObjectTags: tags}, wantErr: "object_tags"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := map[string]*Config{
+				"default job": {Database: db, Storage: tt.storage},
+				"named storage": {
+					Database: db,
+					Storages: map[string]StorageConfig{"offsite": tt.storage},
+					Jobs:     []JobConfig{{Name: "app", Storage: []string{"offsite"}}},
+				},
+			}
+			for shape, c := range configs {
+				_, err := c.ResolveJobs()
+				if tt.wantErr == "" {
+					if err != nil {
+						t.Errorf("%s: ResolveJobs() error = %v", shape, err)
+					}
+					continue
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("%s: ResolveJobs() error = %v, want one mentioning %q", shape, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
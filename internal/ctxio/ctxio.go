@@ -0,0 +1,30 @@
+// Package ctxio makes plain io copies stop when a context is cancelled.
+package ctxio
+
+import (
+	"context"
+	"io"
+)
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewReader returns a reader that fails with the context's error once ctx
+// is cancelled, so an io.Copy from it stops between reads
+func NewReader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Copy is io.Copy that stops when ctx is cancelled
+func Copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, NewReader(ctx, src))
+}
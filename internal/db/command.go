@@ -0,0 +1,23 @@
+package db
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// terminateGrace is how long a dump or restore tool gets to exit after being
+// asked to stop before it is killed
+const terminateGrace = 10 * time.Second
+
+// command builds an external tool invocation that is stopped when ctx is
+// cancelled: the tool is asked to terminate, then killed if it hasn't exited
+// after terminateGrace
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return terminate(cmd)
+	}
+	cmd.WaitDelay = terminateGrace
+	return cmd
+}
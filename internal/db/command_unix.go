@@ -0,0 +1,13 @@
+//go:build !windows
+
+package db
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// terminate asks the tool to shut down cleanly
+func terminate(cmd *exec.Cmd) error {
+	return cmd.Process.Signal(syscall.SIGTERM)
+}
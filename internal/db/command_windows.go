@@ -0,0 +1,10 @@
+//go:build windows
+
+package db
+
+import "os/exec"
+
+// terminate kills the tool; Windows has no signal to ask it to shut down
+func terminate(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -1,21 +1,67 @@
 package db
 
-// Database interface defines the methods that any database provider must implement
+import (
+	"context"
+	"io"
+	"path"
+	"regexp"
+	"time"
+)
+
+// dumpTimeFormat is the timestamp layout embedded in dump file names
+const dumpTimeFormat = "20060102_150405"
+
+// dumpNamePattern matches the "<db>_<timestamp>" stem of a dump file name
+var dumpNamePattern = regexp.MustCompile(`^(.+_(\d{8}_\d{6}))\.`)
+
+// Database interface defines the methods that any database provider must implement.
+// Cancelling the context stops any dump or restore tool the method started.
 type Database interface {
 	// Connect establishes a connection to the database
-	Connect() error
+	Connect(ctx context.Context) error
 
 	// Dump performs a backup of the database to the specified path
 	// returns the path to the backup file and an error if any
-	Dump(destinationPath string) (string, error)
+	Dump(ctx context.Context, destinationPath string) (string, error)
 
 	// Restore attempts to restore the database from the specified file
-	Restore(sourcePath string) error
+	Restore(ctx context.Context, sourcePath string) error
+
+	// Version returns the server or engine version of the connected database
+	Version(ctx context.Context) (string, error)
 
 	// Close closes the database connection
 	Close() error
 }
 
+// Streamer is implemented by databases whose dump tool can write to stdout,
+// which lets a backup be piped straight into storage without a temp file
+type Streamer interface {
+	// DumpFileName returns the file name for a dump taken now
+	DumpFileName() string
+
+	// DumpTo writes a backup of the database to w
+	DumpTo(ctx context.Context, w io.Writer) error
+}
+
+// Inspector is implemented by databases that can summarise their contents,
+// which lets a test restore be compared against the original
+type Inspector interface {
+	// TableCounts returns the number of rows (or documents) in each table
+	// (or collection), keyed by qualified name
+	TableCounts(ctx context.Context) (map[string]int64, error)
+}
+
+// Scratch is implemented by databases that can create and drop the database
+// named in their config, so a backup can be restored into a throwaway copy
+type Scratch interface {
+	// CreateScratch creates the database, failing if it already exists
+	CreateScratch(ctx context.Context) error
+
+	// DropScratch drops the database created by CreateScratch
+	DropScratch(ctx context.Context) error
+}
+
 // Config holds common database configuration parameters
 type Config struct {
 	Type     string
@@ -25,4 +71,24 @@ type Config struct {
 	Password string
 	DBName   string
 	DSN      string
+
+	// SourceDBName is the database name recorded in the dump being restored.
+	// Engines whose dumps carry the database name (MongoDB) use it to remap
+	// the restore onto DBName.
+	SourceDBName string
+}
+
+// ParseDumpName splits a file name produced by DumpFileName (optionally with
+// further extensions such as ".gz") into its "<db>_<timestamp>" stem and the
+// local time the dump was taken. ok is false for names in any other format.
+func ParseDumpName(name string) (stem string, taken time.Time, ok bool) {
+	m := dumpNamePattern.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return "", time.Time{}, false
+	}
+	taken, err := time.ParseInLocation(dumpTimeFormat, m[2], time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return m[1], taken, true
 }
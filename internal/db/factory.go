@@ -5,16 +5,34 @@ import (
 )
 
 func NewDatabase(cfg Config) (Database, error) {
-	switch cfg.Type {
-	case "postgres", "postgresql":
+	switch t, _ := CanonicalType(cfg.Type); t {
+	case "postgres":
 		return NewPostgres(cfg), nil
-	case "sqlite", "sqlite3":
+	case "sqlite":
 		return NewSQLite(cfg), nil
 	case "mysql":
 		return NewMySQL(cfg), nil
-	case "mongodb", "mongo":
+	case "mongodb":
 		return NewMongoDB(cfg), nil
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
 	}
 }
+
+// CanonicalType returns the name a database type goes by, so aliases such
+// as "postgresql" compare equal to "postgres". It reports false for types
+// NewDatabase doesn't support.
+func CanonicalType(t string) (string, bool) {
+	switch t {
+	case "postgres", "postgresql":
+		return "postgres", true
+	case "sqlite", "sqlite3":
+		return "sqlite", true
+	case "mysql":
+		return "mysql", true
+	case "mongodb", "mongo":
+		return "mongodb", true
+	default:
+		return t, false
+	}
+}
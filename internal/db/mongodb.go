@@ -1,12 +1,14 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
+	"io"
 	"path/filepath"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -21,7 +23,7 @@ func NewMongoDB(cfg Config) *MongoDB {
 	return &MongoDB{Config: cfg}
 }
 
-func (m *MongoDB) Connect() error {
+func (m *MongoDB) Connect(ctx context.Context) error {
 	var uri string
 	if m.Config.DSN != "" {
 		uri = m.Config.DSN
@@ -34,7 +36,7 @@ func (m *MongoDB) Connect() error {
 		uri = fmt.Sprintf("mongodb://%s%s:%d", creds, m.Config.Host, m.Config.Port)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
@@ -43,6 +45,7 @@ func (m *MongoDB) Connect() error {
 	}
 
 	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(ctx)
 		return fmt.Errorf("failed to ping mongodb: %w", err)
 	}
 
@@ -54,23 +57,128 @@ func (m *MongoDB) Close() error {
 	if m.client != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		return m.client.Disconnect(ctx)
+		err := m.client.Disconnect(ctx)
+		m.client = nil
+		return err
 	}
 	return nil
 }
 
-func (m *MongoDB) Dump(destinationPath string) (string, error) {
-	// Generate filename
+func (m *MongoDB) Version(ctx context.Context) (string, error) {
+	if m.client == nil {
+		return "", fmt.Errorf("not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var info struct {
+		Version string `bson:"version"`
+	}
+	err := m.client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+	if err != nil {
+		return "", fmt.Errorf("failed to query server version: %w", err)
+	}
+	return info.Version, nil
+}
+
+func (m *MongoDB) TableCounts(ctx context.Context) (map[string]int64, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	dbNames := []string{m.Config.DBName}
+	if m.Config.DBName == "" {
+		names, err := m.client.ListDatabaseNames(ctx, bson.D{
+			{Key: "name", Value: bson.D{{Key: "$nin", Value: bson.A{"admin", "config", "local"}}}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list databases: %w", err)
+		}
+		dbNames = names
+	}
+
+	counts := make(map[string]int64)
+	for _, dbName := range dbNames {
+		database := m.client.Database(dbName)
+		collections, err := database.ListCollectionNames(ctx, bson.D{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list collections in %s: %w", dbName, err)
+		}
+		for _, coll := range collections {
+			n, err := database.Collection(coll).CountDocuments(ctx, bson.D{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to count documents in %s.%s: %w", dbName, coll, err)
+			}
+			// Key by collection only so counts compare across database names
+			key := coll
+			if m.Config.DBName == "" {
+				key = dbName + "." + coll
+			}
+			counts[key] = n
+		}
+	}
+
+	return counts, nil
+}
+
+func (m *MongoDB) CreateScratch(ctx context.Context) error {
+	if m.Config.DBName == "" {
+		return fmt.Errorf("scratch mongodb database requires dbname")
+	}
+	if m.client == nil {
+		if err := m.Connect(ctx); err != nil {
+			return err
+		}
+		defer m.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// MongoDB creates databases implicitly on first write, so all there is to
+	// do is make sure we never restore into (and later drop) an existing one
+	names, err := m.client.ListDatabaseNames(ctx, bson.D{{Key: "name", Value: m.Config.DBName}})
+	if err != nil {
+		return fmt.Errorf("failed to list databases: %w", err)
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("scratch database %s already exists", m.Config.DBName)
+	}
+	return nil
+}
+
+func (m *MongoDB) DropScratch(ctx context.Context) error {
+	if m.client == nil {
+		if err := m.Connect(ctx); err != nil {
+			return err
+		}
+		defer m.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := m.client.Database(m.Config.DBName).Drop(ctx); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", m.Config.DBName, err)
+	}
+	return nil
+}
+
+func (m *MongoDB) DumpFileName() string {
 	dbName := m.Config.DBName
 	if dbName == "" {
 		dbName = "all_dbs"
 	}
-	fileName := fmt.Sprintf("%s_%s.archive", dbName, time.Now().Format("20060102_150405"))
-	fullPath := filepath.Join(destinationPath, fileName)
+	return fmt.Sprintf("%s_%s.archive", dbName, time.Now().Format(dumpTimeFormat))
+}
 
-	// Build mongodump command.
-	// We use --archive to output a single file.
-	args := []string{"--archive=" + fullPath}
+// dumpArgs returns the connection arguments shared by mongodump invocations
+func (m *MongoDB) dumpArgs() []string {
+	var args []string
 
 	if m.Config.DSN != "" {
 		args = append(args, "--uri="+m.Config.DSN)
@@ -88,7 +196,17 @@ func (m *MongoDB) Dump(destinationPath string) (string, error) {
 		}
 	}
 
-	cmd := exec.Command("mongodump", args...)
+	return args
+}
+
+func (m *MongoDB) Dump(ctx context.Context, destinationPath string) (string, error) {
+	fullPath := filepath.Join(destinationPath, m.DumpFileName())
+
+	// Build mongodump command.
+	// We use --archive to output a single file.
+	args := append([]string{"--archive=" + fullPath}, m.dumpArgs()...)
+
+	cmd := command(ctx, "mongodump", args...)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -98,7 +216,24 @@ func (m *MongoDB) Dump(destinationPath string) (string, error) {
 	return fullPath, nil
 }
 
-func (m *MongoDB) Restore(sourcePath string) error {
+func (m *MongoDB) DumpTo(ctx context.Context, w io.Writer) error {
+	// --archive without a value makes mongodump write the archive to stdout
+	args := append([]string{"--archive"}, m.dumpArgs()...)
+
+	cmd := command(ctx, "mongodump", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mongodump failed: %s, output: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+func (m *MongoDB) Restore(ctx context.Context, sourcePath string) error {
 	// Build mongorestore command
 	args := []string{"--archive=" + sourcePath}
 
@@ -118,7 +253,12 @@ func (m *MongoDB) Restore(sourcePath string) error {
 		// but standard restore adds data.
 	}
 
-	cmd := exec.Command("mongorestore", args...)
+	// Restoring into a differently named database, e.g. a scratch copy
+	if m.Config.SourceDBName != "" && m.Config.DBName != "" && m.Config.SourceDBName != m.Config.DBName {
+		args = append(args, "--nsFrom="+m.Config.SourceDBName+".*", "--nsTo="+m.Config.DBName+".*")
+	}
+
+	cmd := command(ctx, "mongorestore", args...)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -1,11 +1,14 @@
 package db
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -20,7 +23,7 @@ func NewMySQL(cfg Config) *MySQL {
 	return &MySQL{Config: cfg}
 }
 
-func (m *MySQL) Connect() error {
+func (m *MySQL) Connect(ctx context.Context) error {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
 		m.Config.User, m.Config.Password, m.Config.Host, m.Config.Port, m.Config.DBName)
 
@@ -29,7 +32,8 @@ func (m *MySQL) Connect() error {
 		return fmt.Errorf("failed to open mysql connection: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to ping mysql database: %w", err)
 	}
 
@@ -44,17 +48,116 @@ func (m *MySQL) Close() error {
 	return nil
 }
 
-func (m *MySQL) Dump(destinationPath string) (string, error) {
-	// Generate filename
-	fileName := fmt.Sprintf("%s_%s.sql", m.Config.DBName, time.Now().Format("20060102_150405"))
-	fullPath := filepath.Join(destinationPath, fileName)
+func (m *MySQL) Version(ctx context.Context) (string, error) {
+	if m.conn == nil {
+		return "", fmt.Errorf("not connected")
+	}
+	var version string
+	if err := m.conn.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to query server version: %w", err)
+	}
+	return version, nil
+}
+
+func (m *MySQL) TableCounts(ctx context.Context) (map[string]int64, error) {
+	if m.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	rows, err := m.conn.QueryContext(ctx, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		var n int64
+		if err := m.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteMySQLIdentifier(table)).Scan(&n); err != nil {
+			return nil, fmt.Errorf("failed to count rows in %s: %w", table, err)
+		}
+		counts[table] = n
+	}
+
+	return counts, nil
+}
+
+// quoteMySQLIdentifier quotes a table or database name for use in a query
+func quoteMySQLIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// adminExec runs a statement on a connection that isn't bound to a database
+func (m *MySQL) adminExec(ctx context.Context, query string) error {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/",
+		m.Config.User, m.Config.Password, m.Config.Host, m.Config.Port)
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open mysql connection: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *MySQL) CreateScratch(ctx context.Context) error {
+	if err := m.adminExec(ctx, "CREATE DATABASE "+quoteMySQLIdentifier(m.Config.DBName)); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", m.Config.DBName, err)
+	}
+	return nil
+}
+
+func (m *MySQL) DropScratch(ctx context.Context) error {
+	if err := m.adminExec(ctx, "DROP DATABASE IF EXISTS "+quoteMySQLIdentifier(m.Config.DBName)); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", m.Config.DBName, err)
+	}
+	return nil
+}
+
+func (m *MySQL) DumpFileName() string {
+	return fmt.Sprintf("%s_%s.sql", m.Config.DBName, time.Now().Format(dumpTimeFormat))
+}
+
+func (m *MySQL) Dump(ctx context.Context, destinationPath string) (string, error) {
+	fullPath := filepath.Join(destinationPath, m.DumpFileName())
+
+	outFile, err := os.Create(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create dump file: %w", err)
+	}
+	defer outFile.Close()
+
+	if err := m.DumpTo(ctx, outFile); err != nil {
+		return "", err
+	}
 
+	return fullPath, nil
+}
+
+func (m *MySQL) DumpTo(ctx context.Context, w io.Writer) error {
 	// mysqldump command
 	// mysqldump -h host -P port -u user -p[password] dbname > outfile
 	// Note: putting password in command args is insecure, better to use cnf file or ENV.
 	// MYSQL_PWD env var is supported by mysqldump.
 
-	cmd := exec.Command("mysqldump",
+	cmd := command(ctx, "mysqldump",
 		"-h", m.Config.Host,
 		"-P", fmt.Sprintf("%d", m.Config.Port),
 		"-u", m.Config.User,
@@ -63,24 +166,20 @@ func (m *MySQL) Dump(destinationPath string) (string, error) {
 
 	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", m.Config.Password))
 
-	outFile, err := os.Create(fullPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create dump file: %w", err)
-	}
-	defer outFile.Close()
-
-	cmd.Stdout = outFile
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("mysqldump failed: %w", err)
+		return fmt.Errorf("mysqldump failed: %w, output: %s", err, stderr.String())
 	}
 
-	return fullPath, nil
+	return nil
 }
 
-func (m *MySQL) Restore(sourcePath string) error {
+func (m *MySQL) Restore(ctx context.Context, sourcePath string) error {
 	// mysql -u user -p dbname < infile
-	cmd := exec.Command("mysql",
+	cmd := command(ctx, "mysql",
 		"-h", m.Config.Host,
 		"-P", fmt.Sprintf("%d", m.Config.Port),
 		"-u", m.Config.User,
@@ -1,14 +1,17 @@
 package db
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Postgres struct {
@@ -20,7 +23,7 @@ func NewPostgres(cfg Config) *Postgres {
 	return &Postgres{Config: cfg}
 }
 
-func (p *Postgres) Connect() error {
+func (p *Postgres) Connect(ctx context.Context) error {
 	var connStr string
 	if p.Config.DSN != "" {
 		connStr = p.Config.DSN
@@ -34,7 +37,8 @@ func (p *Postgres) Connect() error {
 		return fmt.Errorf("failed to open connection: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
@@ -49,34 +53,129 @@ func (p *Postgres) Close() error {
 	return nil
 }
 
-func (p *Postgres) Dump(destinationPath string) (string, error) {
-	// Generate filename if destination is a directory
+func (p *Postgres) Version(ctx context.Context) (string, error) {
+	if p.conn == nil {
+		return "", fmt.Errorf("not connected")
+	}
+	var version string
+	if err := p.conn.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to query server version: %w", err)
+	}
+	return version, nil
+}
+
+func (p *Postgres) TableCounts(ctx context.Context) (map[string]int64, error) {
+	if p.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	rows, err := p.conn.QueryContext(ctx, `SELECT table_schema, table_name FROM information_schema.tables
+		WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	var tables [][2]string
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		tables = append(tables, [2]string{schema, table})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		var n int64
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s.%s", pq.QuoteIdentifier(t[0]), pq.QuoteIdentifier(t[1]))
+		if err := p.conn.QueryRowContext(ctx, query).Scan(&n); err != nil {
+			return nil, fmt.Errorf("failed to count rows in %s.%s: %w", t[0], t[1], err)
+		}
+		counts[t[0]+"."+t[1]] = n
+	}
+
+	return counts, nil
+}
+
+// adminExec runs a statement against the "postgres" maintenance database,
+// for statements that can't run inside the database they affect
+func (p *Postgres) adminExec(ctx context.Context, query string) error {
+	if p.Config.DSN != "" {
+		return fmt.Errorf("scratch postgres databases must be configured with host, port and user rather than dsn")
+	}
+
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=postgres sslmode=disable",
+		p.Config.Host, p.Config.Port, p.Config.User, p.Config.Password)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return fmt.Errorf("failed to open connection: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) CreateScratch(ctx context.Context) error {
+	if err := p.adminExec(ctx, "CREATE DATABASE "+pq.QuoteIdentifier(p.Config.DBName)); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", p.Config.DBName, err)
+	}
+	return nil
+}
+
+func (p *Postgres) DropScratch(ctx context.Context) error {
+	if err := p.adminExec(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(p.Config.DBName)); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", p.Config.DBName, err)
+	}
+	return nil
+}
+
+func (p *Postgres) DumpFileName() string {
 	// Use DBName from config if available, otherwise "db"
 	dbName := p.Config.DBName
 	if dbName == "" {
 		dbName = "db"
 	}
-	fileName := fmt.Sprintf("%s_%s.sql", dbName, time.Now().Format("20060102_150405"))
-	fullPath := filepath.Join(destinationPath, fileName)
+	return fmt.Sprintf("%s_%s.sql", dbName, time.Now().Format(dumpTimeFormat))
+}
 
-	// We'll use pg_dump command
+// dumpCommand builds the pg_dump invocation; extra args are appended as-is
+func (p *Postgres) dumpCommand(ctx context.Context, extraArgs ...string) *exec.Cmd {
 	var cmd *exec.Cmd
 
+	// Ownership and grants name roles the restoring server may not have,
+	// and Restore stops at the first statement that fails
+	extraArgs = append([]string{"--no-owner", "--no-privileges"}, extraArgs...)
+
 	if p.Config.DSN != "" {
 		// If DSN is provided, use it directly as the dbname argument
-		cmd = exec.Command("pg_dump", p.Config.DSN, "-f", fullPath)
+		cmd = command(ctx, "pg_dump", append([]string{p.Config.DSN}, extraArgs...)...)
 	} else {
 		// PGPASSWORD environment variable is used to pass password to pg_dump to avoid prompt
-		cmd = exec.Command("pg_dump",
+		args := []string{
 			"-h", p.Config.Host,
 			"-p", fmt.Sprintf("%d", p.Config.Port),
 			"-U", p.Config.User,
 			"-d", p.Config.DBName,
-			"-f", fullPath,
-		)
+		}
+		cmd = command(ctx, "pg_dump", append(args, extraArgs...)...)
 		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", p.Config.Password))
 	}
 
+	return cmd
+}
+
+func (p *Postgres) Dump(ctx context.Context, destinationPath string) (string, error) {
+	fullPath := filepath.Join(destinationPath, p.DumpFileName())
+
+	cmd := p.dumpCommand(ctx, "-f", fullPath)
+
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("pg_dump failed: %s, output: %s", err, string(output))
@@ -85,20 +184,38 @@ func (p *Postgres) Dump(destinationPath string) (string, error) {
 	return fullPath, nil
 }
 
-func (p *Postgres) Restore(sourcePath string) error {
+func (p *Postgres) DumpTo(ctx context.Context, w io.Writer) error {
+	// Without -f, pg_dump writes the plain SQL dump to stdout
+	cmd := p.dumpCommand(ctx)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_dump failed: %s, output: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+func (p *Postgres) Restore(ctx context.Context, sourcePath string) error {
 	// PGPASSWORD environment variable is used
 	var cmd *exec.Cmd
 
+	// psql carries on past failed statements and still exits 0, so stop at
+	// the first error and roll the whole dump back rather than half-apply it
+	restoreArgs := []string{"-v", "ON_ERROR_STOP=1", "--single-transaction", "-f", sourcePath}
+
 	if p.Config.DSN != "" {
-		cmd = exec.Command("psql", p.Config.DSN, "-f", sourcePath)
+		cmd = command(ctx, "psql", append([]string{p.Config.DSN}, restoreArgs...)...)
 	} else {
-		cmd = exec.Command("psql",
+		cmd = command(ctx, "psql", append([]string{
 			"-h", p.Config.Host,
 			"-p", fmt.Sprintf("%d", p.Config.Port),
 			"-U", p.Config.User,
 			"-d", p.Config.DBName,
-			"-f", sourcePath,
-		)
+		}, restoreArgs...)...)
 		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", p.Config.Password))
 	}
 
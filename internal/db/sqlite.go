@@ -1,11 +1,14 @@
 package db
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -20,7 +23,7 @@ func NewSQLite(cfg Config) *SQLite {
 	return &SQLite{Config: cfg}
 }
 
-func (s *SQLite) Connect() error {
+func (s *SQLite) Connect(ctx context.Context) error {
 	// For SQLite, Host/Port/User/Pass are irrelevant, usually just DBName is the path
 	if s.Config.DBName == "" {
 		return fmt.Errorf("sqlite database path (dbname) is required")
@@ -31,7 +34,8 @@ func (s *SQLite) Connect() error {
 		return fmt.Errorf("failed to open sqlite database: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to ping sqlite database: %w", err)
 	}
 
@@ -46,15 +50,77 @@ func (s *SQLite) Close() error {
 	return nil
 }
 
-func (s *SQLite) Dump(destinationPath string) (string, error) {
-	// Destination file
+func (s *SQLite) Version(ctx context.Context) (string, error) {
+	if s.conn == nil {
+		return "", fmt.Errorf("not connected")
+	}
+	var version string
+	if err := s.conn.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to query sqlite version: %w", err)
+	}
+	return version, nil
+}
+
+func (s *SQLite) TableCounts(ctx context.Context) (map[string]int64, error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	rows, err := s.conn.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		var n int64
+		query := `SELECT COUNT(*) FROM "` + strings.ReplaceAll(table, `"`, `""`) + `"`
+		if err := s.conn.QueryRowContext(ctx, query).Scan(&n); err != nil {
+			return nil, fmt.Errorf("failed to count rows in %s: %w", table, err)
+		}
+		counts[table] = n
+	}
+
+	return counts, nil
+}
+
+func (s *SQLite) CreateScratch(ctx context.Context) error {
+	// The sqlite3 tool creates the file on restore; just make sure we
+	// never restore over an existing database
+	if _, err := os.Stat(s.Config.DBName); err == nil {
+		return fmt.Errorf("scratch database %s already exists", s.Config.DBName)
+	}
+	return nil
+}
+
+func (s *SQLite) DropScratch(ctx context.Context) error {
+	if err := os.Remove(s.Config.DBName); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove scratch database: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) DumpFileName() string {
 	baseName := filepath.Base(s.Config.DBName)
-	fileName := fmt.Sprintf("%s_%s.sql", baseName, time.Now().Format("20060102_150405"))
-	fullPath := filepath.Join(destinationPath, fileName)
+	return fmt.Sprintf("%s_%s.sql", baseName, time.Now().Format(dumpTimeFormat))
+}
 
-	// Use sqlite3 command line tool to dump
-	// syntax: sqlite3 <dbfile> .dump > <outfile>
-	cmd := exec.Command("sqlite3", s.Config.DBName, ".dump")
+func (s *SQLite) Dump(ctx context.Context, destinationPath string) (string, error) {
+	// Destination file
+	fullPath := filepath.Join(destinationPath, s.DumpFileName())
 
 	outFile, err := os.Create(fullPath)
 	if err != nil {
@@ -62,18 +128,32 @@ func (s *SQLite) Dump(destinationPath string) (string, error) {
 	}
 	defer outFile.Close()
 
-	cmd.Stdout = outFile
+	if err := s.DumpTo(ctx, outFile); err != nil {
+		return "", err
+	}
+
+	return fullPath, nil
+}
+
+func (s *SQLite) DumpTo(ctx context.Context, w io.Writer) error {
+	// Use sqlite3 command line tool to dump
+	// syntax: sqlite3 <dbfile> .dump > <outfile>
+	cmd := command(ctx, "sqlite3", s.Config.DBName, ".dump")
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("sqlite3 dump failed: %w", err)
+		return fmt.Errorf("sqlite3 dump failed: %w, output: %s", err, stderr.String())
 	}
 
-	return fullPath, nil
+	return nil
 }
 
-func (s *SQLite) Restore(sourcePath string) error {
+func (s *SQLite) Restore(ctx context.Context, sourcePath string) error {
 	// syntax: sqlite3 <dbfile> < <infile>
-	cmd := exec.Command("sqlite3", s.Config.DBName)
+	cmd := command(ctx, "sqlite3", s.Config.DBName)
 
 	inFile, err := os.Open(sourcePath)
 	if err != nil {
@@ -0,0 +1,19 @@
+// Package format renders values for people to read, shared by the CLI and
+// notifications so both show them the same way.
+package format
+
+import "fmt"
+
+// Bytes renders a byte count in human readable units
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
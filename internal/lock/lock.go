@@ -0,0 +1,65 @@
+// Package lock provides cross-process locks so the same backup job can't be
+// run twice at once, e.g. by the scheduler and a manual `backup`.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the lock
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is a held lock file
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// Acquire takes the lock at path without waiting. If another process holds
+// it, the returned error wraps ErrLocked and describes the holder.
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating lock directory: %w", err)
+	}
+
+	l, err := acquire(path)
+	if errors.Is(err, ErrLocked) {
+		if holder := readHolder(path); holder != "" {
+			return nil, fmt.Errorf("%w (%s)", err, holder)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Record who holds the lock for the error other processes report
+	hostname, _ := os.Hostname()
+	l.file.Truncate(0)
+	fmt.Fprintf(l.file, "pid %d on %s since %s\n", os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+	return l, nil
+}
+
+// Path returns the lock file's path
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Release gives up the lock
+func (l *Lock) Release() error {
+	return release(l)
+}
+
+// readHolder returns the description the holder wrote into the lock file
+func readHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,32 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// acquire creates the lock file exclusively. Unlike flock, the file outlives
+// a crashed process, so a stale lock has to be removed by hand.
+func acquire(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w, remove %s if that process is gone", ErrLocked, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating lock file: %w", err)
+	}
+
+	return &Lock{path: path, file: f}, nil
+}
+
+// release closes and removes the lock file
+func release(l *Lock) error {
+	l.file.Close()
+	if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("removing lock file: %w", err)
+	}
+	return nil
+}
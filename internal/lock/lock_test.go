@@ -0,0 +1,59 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAcquireContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "app.lock")
+
+	held, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.Path() != path {
+		t.Errorf("Path() = %q, want %q", held.Path(), path)
+	}
+
+	// The second attempt fails straight away and names the holder
+	_, err = Acquire(path)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Acquire() on a held lock error = %v, want ErrLocked", err)
+	}
+	if want := fmt.Sprintf("pid %d", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("Acquire() error = %q, want it to mention %q", err, want)
+	}
+
+	if err := held.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() after Release: %v", err)
+	}
+	if err := again.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireIndependentLocks(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := Acquire(filepath.Join(dir, "a.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Release()
+
+	b, err := Acquire(filepath.Join(dir, "b.lock"))
+	if err != nil {
+		t.Fatalf("Acquire() of another lock: %v", err)
+	}
+	defer b.Release()
+}
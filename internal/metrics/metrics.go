@@ -0,0 +1,154 @@
+// Package metrics exposes statistics about backup runs in the Prometheus
+// exposition format.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "backyard_backup"
+
+// phaseOther labels failures that happened outside any backup phase, such
+// as a configuration error
+const phaseOther = "other"
+
+// Run describes a finished backup run
+type Run struct {
+	Job         string
+	Success     bool
+	FailedPhase string // "" if the run succeeded or failed outside any phase
+	Duration    time.Duration
+	Phases      map[string]time.Duration
+	RawSize     int64
+	Size        int64
+	Stored      []string       // Storage targets the artifact was written to
+	Attempts    map[string]int // Attempts made per retried step
+}
+
+// Metrics collects backup statistics for one process
+type Metrics struct {
+	registry *prometheus.Registry
+
+	lastSuccess   *prometheus.GaugeVec
+	runs          *prometheus.CounterVec
+	failures      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	phaseDuration *prometheus.HistogramVec
+	size          *prometheus.GaugeVec
+	rawSize       *prometheus.GaugeVec
+	retries       *prometheus.CounterVec
+	bytesWritten  *prometheus.CounterVec
+}
+
+// durationBuckets span one second to about four and a half hours
+var durationBuckets = prometheus.ExponentialBuckets(1, 2, 15)
+
+// New returns an empty set of metrics, including the Go runtime and process
+// collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time the job last completed a backup successfully.",
+		}, []string{"job"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "runs_total",
+			Help:      "Backup runs by outcome.",
+		}, []string{"job", "result"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "failures_total",
+			Help:      "Failed backup runs by the phase they failed in.",
+		}, []string{"job", "phase"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "duration_seconds",
+			Help:      "Duration of backup runs.",
+			Buckets:   durationBuckets,
+		}, []string{"job"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "phase_duration_seconds",
+			Help:      "Time spent in each phase of a backup run. Streamed backups run their phases concurrently and attribute time to the phase the pipeline waited on.",
+			Buckets:   durationBuckets,
+		}, []string{"job", "phase"}),
+		size: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "artifact_size_bytes",
+			Help:      "Size of the job's latest stored artifact.",
+		}, []string{"job"}),
+		rawSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "artifact_raw_size_bytes",
+			Help:      "Size of the job's latest dump before compression and encryption.",
+		}, []string{"job"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Attempts beyond the first, by backup step.",
+		}, []string{"job", "step"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_written_bytes_total",
+			Help:      "Bytes of artifacts written to each storage target.",
+		}, []string{"job", "storage"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.lastSuccess, m.runs, m.failures, m.duration, m.phaseDuration,
+		m.size, m.rawSize, m.retries, m.bytesWritten,
+	)
+	return m
+}
+
+// AddJob creates the job's run counters at zero, so that they can be
+// alerted on before its first run
+func (m *Metrics) AddJob(job string) {
+	m.runs.WithLabelValues(job, "success")
+	m.runs.WithLabelValues(job, "failure")
+}
+
+// Observe records a finished run
+func (m *Metrics) Observe(r Run) {
+	if r.Success {
+		m.runs.WithLabelValues(r.Job, "success").Inc()
+		m.lastSuccess.WithLabelValues(r.Job).Set(float64(time.Now().Unix()))
+		m.size.WithLabelValues(r.Job).Set(float64(r.Size))
+		m.rawSize.WithLabelValues(r.Job).Set(float64(r.RawSize))
+	} else {
+		phase := r.FailedPhase
+		if phase == "" {
+			phase = phaseOther
+		}
+		m.runs.WithLabelValues(r.Job, "failure").Inc()
+		m.failures.WithLabelValues(r.Job, phase).Inc()
+	}
+
+	m.duration.WithLabelValues(r.Job).Observe(r.Duration.Seconds())
+	for phase, d := range r.Phases {
+		m.phaseDuration.WithLabelValues(r.Job, phase).Observe(d.Seconds())
+	}
+	for step, attempts := range r.Attempts {
+		if attempts > 1 {
+			m.retries.WithLabelValues(r.Job, step).Add(float64(attempts - 1))
+		}
+	}
+	for _, storage := range r.Stored {
+		m.bytesWritten.WithLabelValues(r.Job, storage).Add(float64(r.Size))
+	}
+}
+
+// Handler serves the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the metrics in the text exposition format
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestObserve(t *testing.T) {
+	m := New()
+	m.AddJob("idle")
+	m.Observe(Run{
+		Job:      "orders",
+		Success:  true,
+		Duration: 90 * time.Second,
+		Phases:   map[string]time.Duration{"dump": time.Minute, "upload": 30 * time.Second},
+		RawSize:  4000,
+		Size:     1000,
+		Stored:   []string{"local", "offsite"},
+		Attempts: map[string]int{"connect": 1, "upload to offsite": 3},
+	})
+	m.Observe(Run{Job: "orders", FailedPhase: "upload", Duration: time.Second})
+	m.Observe(Run{Job: "orders", Duration: time.Millisecond})
+
+	tests := []struct {
+		name   string
+		sample string
+	}{
+		{name: "job added before running", sample: `backyard_backup_runs_total{job="idle",result="failure"} 0`},
+		{name: "successes", sample: `backyard_backup_runs_total{job="orders",result="success"} 1`},
+		{name: "failures", sample: `backyard_backup_runs_total{job="orders",result="failure"} 2`},
+		{name: "failed phase", sample: `backyard_backup_failures_total{job="orders",phase="upload"} 1`},
+		{name: "failure outside a phase", sample: `backyard_backup_failures_total{job="orders",phase="other"} 1`},
+		{name: "durations", sample: `backyard_backup_duration_seconds_count{job="orders"} 3`},
+		{name: "phase duration", sample: `backyard_backup_phase_duration_seconds_sum{job="orders",phase="dump"} 60`},
+		{name: "size", sample: `backyard_backup_artifact_size_bytes{job="orders"} 1000`},
+		{name: "raw size", sample: `backyard_backup_artifact_raw_size_bytes{job="orders"} 4000`},
+		{name: "retries", sample: `backyard_backup_retries_total{job="orders",step="upload to offsite"} 2`},
+		{name: "bytes written", sample: `backyard_backup_storage_written_bytes_total{job="orders",storage="offsite"} 1000`},
+		{name: "runtime", sample: "go_goroutines "},
+	}
+
+	body := scrape(t, m)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.sample) {
+				t.Errorf("metrics don't contain %q", tt.sample)
+			}
+		})
+	}
+
+	// Steps that took one attempt aren't retries
+	if strings.Contains(body, `step="connect"`) {
+		t.Error("a step that succeeded first time is counted as retried")
+	}
+	if !strings.Contains(body, `backyard_backup_last_success_timestamp_seconds{job="orders"}`) ||
+		strings.Contains(body, `backyard_backup_last_success_timestamp_seconds{job="idle"}`) {
+		t.Error("last success is only set for jobs that succeeded")
+	}
+}
@@ -0,0 +1,35 @@
+package notify
+
+import "context"
+
+// discordMaxContent is the longest message Discord accepts
+const discordMaxContent = 2000
+
+// Discord posts messages to a Discord channel webhook
+type Discord struct {
+	URL       string
+	Templates *Templates
+}
+
+func NewDiscord(cfg Config) (*Discord, error) {
+	if err := requireURL(cfg); err != nil {
+		return nil, err
+	}
+	templates, err := NewTemplates(cfg.TitleTemplate, cfg.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &Discord{URL: cfg.URL, Templates: templates}, nil
+}
+
+type discordPayload struct {
+	Content string `json:"content"`
+}
+
+func (d *Discord) Notify(ctx context.Context, r Report) error {
+	_, text, err := d.Templates.Render(r)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, d.URL, discordPayload{Content: truncate(text, discordMaxContent)}, nil)
+}
@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpImplicitTLSPort is the submission port that expects TLS from the
+// start rather than upgrading with STARTTLS
+const smtpImplicitTLSPort = 465
+
+// Email sends messages through an SMTP server
+type Email struct {
+	Host      string
+	Port      int
+	Username  string
+	Password  string
+	From      string
+	To        []string
+	Templates *Templates
+}
+
+func NewEmail(cfg Config) (*Email, error) {
+	if cfg.SMTPHost == "" {
+		return nil, fmt.Errorf("email notifier needs an smtp_host")
+	}
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("email notifier needs from and to addresses")
+	}
+
+	templates, err := NewTemplates(cfg.TitleTemplate, cfg.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	port := cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	return &Email{
+		Host:      cfg.SMTPHost,
+		Port:      port,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		From:      cfg.From,
+		To:        cfg.To,
+		Templates: templates,
+	}, nil
+}
+
+func (e *Email) Notify(ctx context.Context, r Report) error {
+	title, text, err := e.Templates.Render(r)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	// net/smtp has no context support, so bound the whole exchange instead
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sendTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if e.Port == smtpImplicitTLSPort {
+		conn = tls.Client(conn, &tls.Config{ServerName: e.Host})
+	}
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if starttls, _ := client.Extension("STARTTLS"); starttls {
+		if err := client.StartTLS(&tls.Config{ServerName: e.Host}); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+	if e.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted
+		// connection to anything but localhost
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if err := client.Mail(e.From); err != nil {
+		return fmt.Errorf("sending from %s: %w", e.From, err)
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("sending to %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("sending message: %w", err)
+	}
+	if _, err := w.Write(e.message(title, text)); err != nil {
+		w.Close()
+		return fmt.Errorf("sending message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("sending message: %w", err)
+	}
+	return client.Quit()
+}
+
+// message formats a plain text email
+func (e *Email) message(subject, text string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,31 @@
+package notify
+
+import "fmt"
+
+// NewNotifier creates a notifier for the channel type in the config
+func NewNotifier(cfg Config) (Notifier, error) {
+	switch cfg.Type {
+	case "slack":
+		return NewSlack(cfg)
+	case "webhook":
+		return NewWebhook(cfg)
+	case "email", "smtp":
+		return NewEmail(cfg)
+	case "teams":
+		return NewTeams(cfg)
+	case "discord":
+		return NewDiscord(cfg)
+	case "ntfy":
+		return NewNtfy(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported notifier type: %s", cfg.Type)
+	}
+}
+
+// requireURL checks that a webhook-based channel has a URL
+func requireURL(cfg Config) error {
+	if cfg.URL == "" {
+		return fmt.Errorf("%s notifier needs a url", cfg.Type)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"strings"
+)
+
+// heartbeatMaxBody is the most of a run's output sent with a ping
+const heartbeatMaxBody = 10000
+
+// HeartbeatConfig holds the URLs a job pings around each run
+type HeartbeatConfig struct {
+	// URL is a healthchecks.io-style check URL. It is pinged on success,
+	// with /start and /fail appended for the other events.
+	URL string
+
+	// Explicit URLs for each event, for monitors with another scheme.
+	// They take precedence over URL.
+	StartURL   string
+	SuccessURL string
+	FailureURL string
+}
+
+// Heartbeat pings a dead man's switch monitor, such as healthchecks.io, when
+// a run starts and finishes. The monitor raises the alarm when the pings
+// stop, which covers runs that never happen at all.
+type Heartbeat struct {
+	StartURL   string
+	SuccessURL string
+	FailureURL string
+}
+
+func NewHeartbeat(cfg HeartbeatConfig) *Heartbeat {
+	h := &Heartbeat{StartURL: cfg.StartURL, SuccessURL: cfg.SuccessURL, FailureURL: cfg.FailureURL}
+	if base := strings.TrimSuffix(cfg.URL, "/"); base != "" {
+		if h.StartURL == "" {
+			h.StartURL = base + "/start"
+		}
+		if h.SuccessURL == "" {
+			h.SuccessURL = base
+		}
+		if h.FailureURL == "" {
+			h.FailureURL = base + "/fail"
+		}
+	}
+	return h
+}
+
+// Start signals that a run has started, so the monitor can also alert on
+// runs that hang
+func (h *Heartbeat) Start(ctx context.Context) error {
+	return ping(ctx, h.StartURL, "")
+}
+
+// Success signals that a run has finished; summary is attached to the ping
+func (h *Heartbeat) Success(ctx context.Context, summary string) error {
+	return ping(ctx, h.SuccessURL, summary)
+}
+
+// Failure signals that a run has failed with err
+func (h *Heartbeat) Failure(ctx context.Context, err error) error {
+	return ping(ctx, h.FailureURL, err.Error())
+}
+
+// ping posts body to url, doing nothing if url is empty
+func ping(ctx context.Context, url, body string) error {
+	if url == "" {
+		return nil
+	}
+	return post(ctx, url, "text/plain; charset=utf-8", []byte(truncate(body, heartbeatMaxBody)), nil)
+}
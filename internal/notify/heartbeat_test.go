@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewHeartbeat(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  HeartbeatConfig
+		want Heartbeat
+	}{
+		{name: "disabled", cfg: HeartbeatConfig{}, want: Heartbeat{}},
+		{
+			name: "check url",
+			cfg:  HeartbeatConfig{URL: "https://hc-ping.com/abc"},
+			want: Heartbeat{StartURL: "https://hc-ping.com/abc/start", SuccessURL: "https://hc-ping.com/abc", FailureURL: "https://hc-ping.com/abc/fail"},
+		},
+		{
+			name: "trailing slash",
+			cfg:  HeartbeatConfig{URL: "https://hc-ping.com/abc/"},
+			want: Heartbeat{StartURL: "https://hc-ping.com/abc/start", SuccessURL: "https://hc-ping.com/abc", FailureURL: "https://hc-ping.com/abc/fail"},
+		},
+		{
+			name: "explicit urls win",
+			cfg:  HeartbeatConfig{URL: "https://hc-ping.com/abc", FailureURL: "https://monitor.example/down"},
+			want: Heartbeat{StartURL: "https://hc-ping.com/abc/start", SuccessURL: "https://hc-ping.com/abc", FailureURL: "https://monitor.example/down"},
+		},
+		{
+			name: "explicit urls only",
+			cfg:  HeartbeatConfig{SuccessURL: "https://monitor.example/up"},
+			want: Heartbeat{SuccessURL: "https://monitor.example/up"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewHeartbeat(tt.cfg); *got != tt.want {
+				t.Errorf("NewHeartbeat() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeartbeatPings(t *testing.T) {
+	var mu sync.Mutex
+	pings := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pings[r.URL.Path] = string(body)
+		mu.Unlock()
+		if r.URL.Path == "/broken/fail" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	ping := func(path string) (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, ok := pings[path]
+		return body, ok
+	}
+
+	h := NewHeartbeat(HeartbeatConfig{URL: srv.URL + "/check"})
+	if err := h.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Success(ctx, "Stored app.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Failure(ctx, errors.New(strings.Repeat("x", heartbeatMaxBody+100))); err != nil {
+		t.Fatal(err)
+	}
+
+	if body, ok := ping("/check/start"); !ok || body != "" {
+		t.Errorf("start ping = %q, %t", body, ok)
+	}
+	if body, _ := ping("/check"); body != "Stored app.sql.gz" {
+		t.Errorf("success ping body = %q", body)
+	}
+	if body, _ := ping("/check/fail"); len([]rune(body)) != heartbeatMaxBody || !strings.HasSuffix(body, "…") {
+		t.Errorf("failure ping body is %d runes, want it cut to %d", len([]rune(body)), heartbeatMaxBody)
+	}
+
+	if err := NewHeartbeat(HeartbeatConfig{URL: srv.URL + "/broken"}).Failure(ctx, errors.New("boom")); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Failure() to a missing check error = %v, want the status", err)
+	}
+	// A heartbeat without URLs pings nothing
+	if err := NewHeartbeat(HeartbeatConfig{}).Start(ctx); err != nil {
+		t.Errorf("Start() without URLs: %v", err)
+	}
+}
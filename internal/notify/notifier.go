@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Status is the outcome of the run a report describes
+type Status string
+
+const (
+	StatusSuccess Status = "success"
+	StatusFailure Status = "failure"
+)
+
+// Kind is the sort of run a report describes
+type Kind string
+
+const (
+	KindBackup Kind = "backup"
+	KindVerify Kind = "verify"
+)
+
+// Report describes a finished backup or verify run. Message templates are
+// executed with it.
+type Report struct {
+	Kind     Kind
+	Status   Status
+	Job      string
+	Database string // Engine and database name, e.g. "postgres:orders"
+	Artifact string // Stored file name, or the backup ID for verify runs
+	RawSize  int64  // Size of the dump before compression
+	Size     int64  // Size of the stored artifact
+	Duration time.Duration
+	Storage  []string       // Locations holding the backup, e.g. "offsite: s3://bucket/orders/..."
+	Attempts map[string]int // Attempts made per step
+	Retries  string         // Summary of the steps that needed several attempts, "" if none
+	Tables   int            // Tables checked by a verify run
+	Error    string
+	Time     time.Time // When the run finished
+}
+
+// Failed reports whether the run failed
+func (r Report) Failed() bool {
+	return r.Status == StatusFailure
+}
+
+// Ratio is the compression ratio of the artifact, or 0 if it is unknown
+func (r Report) Ratio() float64 {
+	if r.Size == 0 {
+		return 0
+	}
+	return float64(r.RawSize) / float64(r.Size)
+}
+
+// Notifier delivers reports to a notification channel
+type Notifier interface {
+	// Notify sends a message about the run, giving up when ctx is done
+	Notify(ctx context.Context, r Report) error
+}
+
+// Config holds the settings of a notification channel
+type Config struct {
+	Type string // "slack", "webhook", "email", "teams", "discord", "ntfy"
+	URL  string // Webhook URL, or the topic URL for ntfy
+
+	// text/template sources for the message title and text, executed with a
+	// Report. Empty uses the defaults.
+	TitleTemplate string
+	Template      string
+
+	Headers  map[string]string // Extra HTTP headers for webhook
+	Token    string            // Access token for ntfy
+	Priority string            // Message priority for ntfy
+
+	// SMTP settings for email
+	SMTPHost string
+	SMTPPort int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// sendTimeout bounds a single delivery attempt
+const sendTimeout = 10 * time.Second
+
+// httpClient is shared by the notifiers that post to webhooks
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+// postJSON posts payload to url as JSON
+func postJSON(ctx context.Context, url string, payload interface{}, headers map[string]string) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling payload: %w", err)
+	}
+	return post(ctx, url, "application/json", data, headers)
+}
+
+// post sends body to url and fails on any status other than 2xx
+func post(ctx context.Context, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Enough of the body to show the service's error message
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
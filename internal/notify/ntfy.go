@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"context"
+	"mime"
+)
+
+// Ntfy publishes messages to an ntfy topic
+type Ntfy struct {
+	URL       string // Topic URL, e.g. https://ntfy.sh/my-backups
+	Token     string
+	Priority  string
+	Templates *Templates
+}
+
+func NewNtfy(cfg Config) (*Ntfy, error) {
+	if err := requireURL(cfg); err != nil {
+		return nil, err
+	}
+	templates, err := NewTemplates(cfg.TitleTemplate, cfg.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &Ntfy{URL: cfg.URL, Token: cfg.Token, Priority: cfg.Priority, Templates: templates}, nil
+}
+
+func (n *Ntfy) Notify(ctx context.Context, r Report) error {
+	title, text, err := n.Templates.Render(r)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		// ntfy decodes RFC 2047 headers, so titles aren't limited to ASCII
+		"Title": mime.QEncoding.Encode("utf-8", title),
+		"Tags":  "white_check_mark",
+	}
+	if r.Failed() {
+		headers["Tags"] = "rotating_light"
+	}
+	if n.Priority != "" {
+		headers["Priority"] = n.Priority
+	}
+	if n.Token != "" {
+		headers["Authorization"] = "Bearer " + n.Token
+	}
+	return post(ctx, n.URL, "text/plain; charset=utf-8", []byte(text), headers)
+}
@@ -1,36 +1,110 @@
 package notify
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
-	"time"
+	"strings"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/format"
 )
 
-type SlackPayload struct {
-	Text string `json:"text"`
+// Slack posts messages to a Slack incoming webhook. Without a custom
+// template the message is laid out with Block Kit.
+type Slack struct {
+	URL       string
+	Templates *Templates
 }
 
-func SendSlackNotification(webhookURL, message string) error {
-	payload := SlackPayload{Text: message}
-	data, err := json.Marshal(payload)
+func NewSlack(cfg Config) (*Slack, error) {
+	if err := requireURL(cfg); err != nil {
+		return nil, err
+	}
+	templates, err := NewTemplates(cfg.TitleTemplate, cfg.Template)
 	if err != nil {
-		return fmt.Errorf("marshaling payload: %w", err)
+		return nil, err
 	}
+	return &Slack{URL: cfg.URL, Templates: templates}, nil
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post(webhookURL, "application/json", bytes.NewBuffer(data))
+type slackPayload struct {
+	Text   string       `json:"text"` // Shown in notifications, and instead of blocks by old clients
+	Blocks []slackBlock `json:"blocks,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+func (s *Slack) Notify(ctx context.Context, r Report) error {
+	title, text, err := s.Templates.Render(r)
 	if err != nil {
-		return fmt.Errorf("sending request: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
-		return fmt.Errorf("slack returned status %d: %s", resp.StatusCode, buf.String())
+	payload := slackPayload{Text: text}
+	if !s.Templates.Custom() {
+		payload = slackPayload{Text: title, Blocks: slackBlocks(title, r)}
 	}
+	return postJSON(ctx, s.URL, payload, nil)
+}
+
+// slackMaxFields is the most fields Slack allows in a section block
+const slackMaxFields = 10
 
-	return nil
+// slackBlocks lays out a report as a header, a grid of run statistics, and
+// the error if the run failed
+func slackBlocks(title string, r Report) []slackBlock {
+	icon := ":white_check_mark:"
+	if r.Failed() {
+		icon = ":rotating_light:"
+	}
+
+	var fields []slackText
+	field := func(name, value string) {
+		if value != "" && len(fields) < slackMaxFields {
+			fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", name, value)})
+		}
+	}
+	field("Job", r.Job)
+	field("Database", r.Database)
+	if r.Kind == KindVerify {
+		field("Backup", "`"+r.Artifact+"`")
+	} else if r.Artifact != "" {
+		field("Artifact", "`"+r.Artifact+"`")
+	}
+	field("Duration", formatDuration(r.Duration))
+	if r.Size > 0 {
+		field("Size", format.Bytes(r.Size))
+		field("Compression", fmt.Sprintf("%s uncompressed, %.1fx", format.Bytes(r.RawSize), r.Ratio()))
+	}
+	if len(r.Storage) > 0 {
+		field("Stored in", strings.Join(r.Storage, "\n"))
+	}
+	if r.Tables > 0 {
+		field("Tables checked", fmt.Sprint(r.Tables))
+	}
+	field("Retries", r.Retries)
+
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: icon + " " + title, Emoji: true}},
+		{Type: "section", Fields: fields},
+	}
+	if r.Error != "" {
+		// Section text is limited to 3000 characters
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "*Error*\n```" + truncate(r.Error, 2900) + "```"}})
+	}
+	blocks = append(blocks, slackBlock{
+		Type:     "context",
+		Elements: []slackText{{Type: "mrkdwn", Text: fmt.Sprintf("Finished <!date^%d^{date_short_pretty} at {time_secs}|%s>", r.Time.Unix(), r.Time.Format("2006-01-02 15:04:05 MST"))}},
+	})
+	return blocks
 }
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"strings"
+)
+
+// Teams posts messages to a Microsoft Teams workflow webhook as an Adaptive Card
+type Teams struct {
+	URL       string
+	Templates *Templates
+}
+
+func NewTeams(cfg Config) (*Teams, error) {
+	if err := requireURL(cfg); err != nil {
+		return nil, err
+	}
+	templates, err := NewTemplates(cfg.TitleTemplate, cfg.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &Teams{URL: cfg.URL, Templates: templates}, nil
+}
+
+type teamsPayload struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string           `json:"$schema"`
+	Type    string           `json:"type"`
+	Version string           `json:"version"`
+	Body    []teamsTextBlock `json:"body"`
+}
+
+type teamsTextBlock struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Weight string `json:"weight,omitempty"`
+	Size   string `json:"size,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Wrap   bool   `json:"wrap"`
+}
+
+func (t *Teams) Notify(ctx context.Context, r Report) error {
+	title, text, err := t.Templates.Render(r)
+	if err != nil {
+		return err
+	}
+	color := "Good"
+	if r.Failed() {
+		color = "Attention"
+	}
+
+	payload := teamsPayload{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: teamsCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body: []teamsTextBlock{
+					{Type: "TextBlock", Text: title, Weight: "Bolder", Size: "Medium", Color: color, Wrap: true},
+					{Type: "TextBlock", Text: teamsText(text), Wrap: true},
+				},
+			},
+		}},
+	}
+	return postJSON(ctx, t.URL, payload, nil)
+}
+
+// teamsText keeps the line breaks of a plain text message, which Adaptive
+// Card markdown would otherwise join into one paragraph
+func teamsText(text string) string {
+	return strings.ReplaceAll(text, "\n", "\n\n")
+}
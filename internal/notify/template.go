@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/format"
+)
+
+// DefaultTitleTemplate is the message title used unless a channel sets its own
+const DefaultTitleTemplate = `{{if eq .Kind "verify"}}{{if .Failed}}Verification of backup {{.Artifact}} failed{{else}}Backup {{.Artifact}} verified{{end}}` +
+	`{{else}}Backup job {{.Job}} {{if .Failed}}failed{{else}}succeeded{{end}}{{end}}`
+
+// DefaultTemplate is the message text used unless a channel sets its own
+const DefaultTemplate = `{{if .Failed}}🚨{{else}}✅{{end}} {{template "title" .}} {{if .Failed}}after{{else}}in{{end}} {{duration .Duration}}
+Job: {{.Job}}
+Database: {{.Database}}
+{{- if .Artifact}}
+{{if eq .Kind "verify"}}Backup{{else}}Artifact{{end}}: {{.Artifact}}{{end}}
+{{- if .Size}}
+Size: {{bytes .Size}} ({{bytes .RawSize}} uncompressed, {{printf "%.1f" .Ratio}}x){{end}}
+{{- if .Storage}}
+Stored in: {{join .Storage ", "}}{{end}}
+{{- if .Tables}}
+Tables checked: {{.Tables}}{{end}}
+{{- if .Retries}}
+Retries: {{.Retries}}{{end}}
+{{- if .Error}}
+Error: {{.Error}}{{end}}`
+
+// templateFuncs are available to message templates besides the built-ins
+var templateFuncs = template.FuncMap{
+	"bytes":    format.Bytes,
+	"duration": formatDuration,
+	"join":     strings.Join,
+}
+
+// Templates renders the title and text of a channel's messages
+type Templates struct {
+	title  *template.Template
+	text   *template.Template
+	custom bool // The text template was set by the user
+}
+
+// NewTemplates parses the title and text templates of a channel. Empty
+// sources use the defaults.
+func NewTemplates(title, text string) (*Templates, error) {
+	t := &Templates{custom: text != ""}
+	if title == "" {
+		title = DefaultTitleTemplate
+	}
+	if text == "" {
+		text = DefaultTemplate
+	}
+
+	var err error
+	if t.title, err = template.New("title").Funcs(templateFuncs).Parse(title); err != nil {
+		return nil, fmt.Errorf("parsing title template: %w", err)
+	}
+	// The text template can include the title with {{template "title" .}}
+	if t.text, err = template.Must(t.title.Clone()).New("text").Parse(text); err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	return t, nil
+}
+
+// Render returns the message title and text for a report
+func (t *Templates) Render(r Report) (title, text string, err error) {
+	if title, err = execute(t.title, r); err != nil {
+		return "", "", err
+	}
+	if text, err = execute(t.text, r); err != nil {
+		return "", "", err
+	}
+	return title, text, nil
+}
+
+// Custom reports whether the channel has its own text template
+func (t *Templates) Custom() bool {
+	return t.custom
+}
+
+func execute(tmpl *template.Template, r Report) (string, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("rendering %s template: %w", tmpl.Name(), err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// formatDuration rounds a duration to a precision worth reading
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Millisecond).String()
+	}
+}
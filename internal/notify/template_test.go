@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderDefaults(t *testing.T) {
+	backup := Report{
+		Kind:     KindBackup,
+		Status:   StatusSuccess,
+		Job:      "orders",
+		Database: "postgres:orders",
+		Artifact: "orders_20240301_020000.sql.gz",
+		RawSize:  4 << 20,
+		Size:     1 << 20,
+		Duration: 83*time.Second + 400*time.Millisecond,
+		Storage:  []string{"local: /backups/orders", "offsite: s3://bucket/orders"},
+	}
+	failed := backup
+	failed.Status, failed.Error, failed.Size, failed.Storage = StatusFailure, "pg_dump: connection refused", 0, nil
+	failed.Retries = "connect (3 attempts)"
+	verify := Report{Kind: KindVerify, Status: StatusSuccess, Job: "orders", Database: "postgres:orders", Artifact: "orders_20240301_020000", Duration: 350 * time.Millisecond, Tables: 12}
+
+	tests := []struct {
+		name      string
+		report    Report
+		wantTitle string
+		wantText  []string // lines the text must contain
+		notText   []string
+	}{
+		{
+			name:      "backup succeeded",
+			report:    backup,
+			wantTitle: "Backup job orders succeeded",
+			wantText: []string{
+				"✅ Backup job orders succeeded in 1m23s",
+				"Artifact: orders_20240301_020000.sql.gz",
+				"Size: 1.0 MiB (4.0 MiB uncompressed, 4.0x)",
+				"Stored in: local: /backups/orders, offsite: s3://bucket/orders",
+			},
+			notText: []string{"Error", "Retries", "Tables"},
+		},
+		{
+			name:      "backup failed",
+			report:    failed,
+			wantTitle: "Backup job orders failed",
+			wantText:  []string{"🚨 Backup job orders failed after 1m23s", "Retries: connect (3 attempts)", "Error: pg_dump: connection refused"},
+			notText:   []string{"Size", "Stored in"},
+		},
+		{
+			name:      "verified",
+			report:    verify,
+			wantTitle: "Backup orders_20240301_020000 verified",
+			wantText:  []string{"in 350ms", "Backup: orders_20240301_020000", "Tables checked: 12"},
+			notText:   []string{"Artifact"},
+		},
+	}
+
+	tmpl, err := NewTemplates("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.Custom() {
+		t.Error("Custom() = true for the default templates")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, text, err := tmpl.Render(tt.report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", title, tt.wantTitle)
+			}
+			for _, want := range tt.wantText {
+				if !strings.Contains(text, want) {
+					t.Errorf("text doesn't contain %q:\n%s", want, text)
+				}
+			}
+			for _, unwanted := range tt.notText {
+				if strings.Contains(text, unwanted) {
+					t.Errorf("text contains %q:\n%s", unwanted, text)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderCustom(t *testing.T) {
+	report := Report{Kind: KindBackup, Status: StatusFailure, Job: "orders", Size: 1536, Duration: 2 * time.Hour, Error: "disk full"}
+
+	tests := []struct {
+		name      string
+		title     string
+		text      string
+		wantTitle string
+		wantText  string
+		wantErr   string
+	}{
+		{name: "custom text", text: "{{.Job}}: {{.Status}} ({{.Error}})", wantTitle: "Backup job orders failed", wantText: "orders: failure (disk full)"},
+		{name: "custom title", title: "[{{.Status}}] {{.Job}}", text: "{{template \"title\" .}}", wantTitle: "[failure] orders", wantText: "[failure] orders"},
+		{name: "functions", text: "{{bytes .Size}} in {{duration .Duration}}, {{join .Storage \"+\"}}", wantTitle: "Backup job orders failed", wantText: "1.5 KiB in 2h0m0s,"},
+		{name: "bad text", text: "{{.Job", wantErr: "parsing template"},
+		{name: "bad title", title: "{{if}}", wantErr: "parsing title template"},
+		{name: "unknown field", text: "{{.Nope}}", wantErr: "rendering text template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := NewTemplates(tt.title, tt.text)
+			var title, text string
+			if err == nil {
+				if !tmpl.Custom() {
+					t.Error("Custom() = false for a channel template")
+				}
+				title, text, err = tmpl.Render(report)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if title != tt.wantTitle || text != tt.wantText {
+				t.Errorf("Render() = %q, %q, want %q, %q", title, text, tt.wantTitle, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 1234567 * time.Microsecond, want: "1.2s"},
+		{d: 83*time.Second + 600*time.Millisecond, want: "1m24s"},
+		{d: 1234 * time.Microsecond, want: "1ms"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// Webhook posts messages as JSON to any HTTP endpoint
+type Webhook struct {
+	URL       string
+	Headers   map[string]string
+	Templates *Templates
+}
+
+func NewWebhook(cfg Config) (*Webhook, error) {
+	if err := requireURL(cfg); err != nil {
+		return nil, err
+	}
+	templates, err := NewTemplates(cfg.TitleTemplate, cfg.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &Webhook{URL: cfg.URL, Headers: cfg.Headers, Templates: templates}, nil
+}
+
+// webhookPayload is the JSON body a webhook receives: the rendered message
+// followed by the run statistics
+type webhookPayload struct {
+	Kind     Kind    `json:"kind"`
+	Status   Status  `json:"status"`
+	Title    string  `json:"title"`
+	Text     string  `json:"text"`
+	Job      string  `json:"job"`
+	Database string  `json:"database"`
+	Artifact string  `json:"artifact,omitempty"`
+	RawSize  int64   `json:"raw_size,omitempty"`
+	Size     int64   `json:"size,omitempty"`
+	Ratio    float64 `json:"compression_ratio,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+
+	Storage  []string       `json:"storage,omitempty"`
+	Attempts map[string]int `json:"attempts,omitempty"`
+	Tables   int            `json:"tables,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Time     time.Time      `json:"time"`
+}
+
+func (w *Webhook) Notify(ctx context.Context, r Report) error {
+	title, text, err := w.Templates.Render(r)
+	if err != nil {
+		return err
+	}
+
+	payload := webhookPayload{
+		Kind:     r.Kind,
+		Status:   r.Status,
+		Title:    title,
+		Text:     text,
+		Job:      r.Job,
+		Database: r.Database,
+		Artifact: r.Artifact,
+		RawSize:  r.RawSize,
+		Size:     r.Size,
+		Ratio:    r.Ratio(),
+		Duration: r.Duration.Seconds(),
+		Storage:  r.Storage,
+		Attempts: r.Attempts,
+		Tables:   r.Tables,
+		Error:    r.Error,
+		Time:     r.Time.UTC(),
+	}
+	return postJSON(ctx, w.URL, payload, w.Headers)
+}
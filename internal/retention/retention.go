@@ -0,0 +1,177 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy describes which backups to keep using grandfather-father-son rules.
+// A backup is kept if any keep rule selects it; MaxAge then removes anything
+// older regardless. The most recent backup is never pruned.
+type Policy struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	MaxAge      time.Duration
+}
+
+// Backup is a single backup considered by a policy
+type Backup struct {
+	Key  string
+	Time time.Time
+}
+
+// Decision records whether a backup is kept and why
+type Decision struct {
+	Backup  Backup
+	Keep    bool
+	Reasons []string
+}
+
+// IsZero reports whether the policy has no rules, i.e. keeps everything
+func (p Policy) IsZero() bool {
+	return !p.hasKeepRules() && p.MaxAge == 0
+}
+
+func (p Policy) hasKeepRules() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.KeepYearly > 0
+}
+
+// day is the length of the days, weeks, months and years in LockPeriod
+const day = 24 * time.Hour
+
+// LockPeriod is how long a new backup should be protected from deletion:
+// the longest span covered by the daily, weekly, monthly or yearly rules in
+// use, capped at MaxAge. Any backup may turn out to be the one the coarsest
+// rule keeps, so locking each that long means everything the policy
+// promises to keep survives someone who can delete from the storage. Months
+// count as 30 days and years as 365. It is zero for a policy that only
+// counts backups.
+func (p Policy) LockPeriod() time.Duration {
+	var period time.Duration
+	for _, span := range []time.Duration{
+		time.Duration(p.KeepDaily) * day,
+		time.Duration(p.KeepWeekly) * 7 * day,
+		time.Duration(p.KeepMonthly) * 30 * day,
+		time.Duration(p.KeepYearly) * 365 * day,
+	} {
+		if span > period {
+			period = span
+		}
+	}
+	if p.MaxAge > 0 && (period == 0 || p.MaxAge < period) {
+		period = p.MaxAge
+	}
+	return period
+}
+
+// bucketRule keeps the newest backup of each of the last Count periods
+type bucketRule struct {
+	name   string
+	count  int
+	bucket func(t time.Time) string
+}
+
+// Apply evaluates the policy against backups and returns one decision per
+// backup, ordered newest first
+func Apply(p Policy, backups []Backup, now time.Time) []Decision {
+	sorted := make([]Backup, len(backups))
+	copy(sorted, backups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	rules := []bucketRule{
+		{"daily", p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{"monthly", p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	lastBucket := make([]string, len(rules))
+	kept := make([]int, len(rules))
+
+	decisions := make([]Decision, len(sorted))
+	for i, b := range sorted {
+		d := Decision{Backup: b}
+
+		if i == 0 {
+			d.Keep = true
+			d.Reasons = append(d.Reasons, "latest backup")
+		}
+
+		if !p.hasKeepRules() && i > 0 {
+			d.Keep = true
+			if p.MaxAge > 0 {
+				d.Reasons = append(d.Reasons, fmt.Sprintf("within max age %s", p.MaxAge))
+			} else {
+				d.Reasons = append(d.Reasons, "no retention rules")
+			}
+		}
+
+		if i < p.KeepLast {
+			d.Keep = true
+			d.Reasons = append(d.Reasons, fmt.Sprintf("last %d", p.KeepLast))
+		}
+
+		t := b.Time.Local()
+		for r, rule := range rules {
+			if rule.count == 0 || kept[r] >= rule.count {
+				continue
+			}
+			bucket := rule.bucket(t)
+			if bucket == lastBucket[r] {
+				continue
+			}
+			lastBucket[r] = bucket
+			kept[r]++
+			d.Keep = true
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%s %s", rule.name, bucket))
+		}
+
+		if p.MaxAge > 0 && i > 0 && now.Sub(b.Time) > p.MaxAge {
+			d.Keep = false
+			d.Reasons = []string{fmt.Sprintf("older than max age %s", p.MaxAge)}
+		}
+
+		if !d.Keep && len(d.Reasons) == 0 {
+			d.Reasons = append(d.Reasons, "not selected by any keep rule")
+		}
+
+		decisions[i] = d
+	}
+
+	return decisions
+}
+
+// ParseMaxAge parses a duration that, in addition to the units understood by
+// time.ParseDuration, accepts whole days ("30d") and weeks ("4w")
+func ParseMaxAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid max age %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max age %q: %w", s, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,237 @@
+package retention
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// at returns noon on the given day of 2024, local time, since Apply buckets
+// backups by the local calendar
+func at(month time.Month, day int) time.Time {
+	return time.Date(2024, month, day, 12, 0, 0, 0, time.Local)
+}
+
+// daily returns one backup per day for n days ending on end, newest first
+func daily(end time.Time, n int) []Backup {
+	backups := make([]Backup, n)
+	for i := range backups {
+		t := end.AddDate(0, 0, -i)
+		backups[i] = Backup{Key: t.Format("2006-01-02"), Time: t}
+	}
+	return backups
+}
+
+// kept returns the keys of the backups the decisions keep, in order
+func kept(decisions []Decision) []string {
+	var keys []string
+	for _, d := range decisions {
+		if d.Keep {
+			keys = append(keys, d.Backup.Key)
+		}
+	}
+	return keys
+}
+
+func TestApply(t *testing.T) {
+	now := at(time.March, 31)
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		backups []Backup
+		want    []string
+	}{
+		{
+			name:    "empty policy keeps everything",
+			policy:  Policy{},
+			backups: daily(now, 3),
+			want:    []string{"2024-03-31", "2024-03-30", "2024-03-29"},
+		},
+		{
+			name:    "keep last",
+			policy:  Policy{KeepLast: 2},
+			backups: daily(now, 5),
+			want:    []string{"2024-03-31", "2024-03-30"},
+		},
+		{
+			name:    "keep daily",
+			policy:  Policy{KeepDaily: 3},
+			backups: daily(now, 10),
+			want:    []string{"2024-03-31", "2024-03-30", "2024-03-29"},
+		},
+		{
+			name:   "keep daily takes the newest of each day",
+			policy: Policy{KeepDaily: 2},
+			backups: []Backup{
+				{Key: "31-early", Time: now.Add(-2 * time.Hour)},
+				{Key: "31-late", Time: now},
+				{Key: "30-late", Time: now.Add(-22 * time.Hour)},
+				{Key: "30-early", Time: now.Add(-26 * time.Hour)},
+			},
+			want: []string{"31-late", "30-late"},
+		},
+		{
+			// 2024-03-31 is a Sunday, so each ISO week ends on a backup
+			name:    "keep weekly",
+			policy:  Policy{KeepWeekly: 2},
+			backups: daily(now, 14),
+			want:    []string{"2024-03-31", "2024-03-24"},
+		},
+		{
+			name:    "keep monthly",
+			policy:  Policy{KeepMonthly: 3},
+			backups: daily(now, 100),
+			want:    []string{"2024-03-31", "2024-02-29", "2024-01-31"},
+		},
+		{
+			name:    "keep yearly",
+			policy:  Policy{KeepYearly: 2},
+			backups: daily(now, 400),
+			want:    []string{"2024-03-31", "2023-12-31"},
+		},
+		{
+			name:    "rules combine",
+			policy:  Policy{KeepDaily: 2, KeepMonthly: 2},
+			backups: daily(now, 40),
+			want:    []string{"2024-03-31", "2024-03-30", "2024-02-29"},
+		},
+		{
+			name:    "max age overrides keep rules",
+			policy:  Policy{KeepDaily: 10, MaxAge: 3 * 24 * time.Hour},
+			backups: daily(now, 10),
+			want:    []string{"2024-03-31", "2024-03-30", "2024-03-29", "2024-03-28"},
+		},
+		{
+			name:    "max age alone",
+			policy:  Policy{MaxAge: 24 * time.Hour},
+			backups: daily(now, 5),
+			want:    []string{"2024-03-31", "2024-03-30"},
+		},
+		{
+			name:    "latest backup survives max age",
+			policy:  Policy{MaxAge: time.Hour},
+			backups: daily(now.AddDate(0, 0, -10), 3),
+			want:    []string{"2024-03-21"},
+		},
+		{
+			name:    "input order doesn't matter",
+			policy:  Policy{KeepLast: 1},
+			backups: []Backup{{Key: "old", Time: now.Add(-time.Hour)}, {Key: "new", Time: now}},
+			want:    []string{"new"},
+		},
+		{
+			name:    "no backups",
+			policy:  Policy{KeepLast: 1},
+			backups: nil,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := Apply(tt.policy, tt.backups, now)
+			if len(decisions) != len(tt.backups) {
+				t.Fatalf("got %d decisions for %d backups", len(decisions), len(tt.backups))
+			}
+			if got := kept(decisions); !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+			for i, d := range decisions {
+				if len(d.Reasons) == 0 {
+					t.Errorf("decision for %s has no reason", d.Backup.Key)
+				}
+				if i > 0 && d.Backup.Time.After(decisions[i-1].Backup.Time) {
+					t.Errorf("decisions not ordered newest first at %s", d.Backup.Key)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyReasons(t *testing.T) {
+	now := at(time.March, 31)
+	decisions := Apply(Policy{KeepLast: 1, KeepDaily: 1, MaxAge: 24 * time.Hour}, daily(now, 3), now)
+
+	want := [][]string{
+		{"latest backup", "last 1", "daily 2024-03-31"},
+		{"not selected by any keep rule"},
+		{fmt.Sprintf("older than max age %s", 24*time.Hour)},
+	}
+	for i, d := range decisions {
+		if !slices.Equal(d.Reasons, want[i]) {
+			t.Errorf("%s: reasons %q, want %q", d.Backup.Key, d.Reasons, want[i])
+		}
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "  ", want: 0},
+		{in: "30d", want: 30 * 24 * time.Hour},
+		{in: "0d", want: 0},
+		{in: "4w", want: 4 * 7 * 24 * time.Hour},
+		{in: " 2w ", want: 2 * 7 * 24 * time.Hour},
+		{in: "36h", want: 36 * time.Hour},
+		{in: "90m", want: 90 * time.Minute},
+		{in: "1h30m", want: 90 * time.Minute},
+		{in: "1.5d", wantErr: true},
+		{in: "-1d", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "2x", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMaxAge(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMaxAge(%q) = %s, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMaxAge(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMaxAge(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockPeriod(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   time.Duration
+	}{
+		{name: "no rules", policy: Policy{}, want: 0},
+		{name: "keep last only", policy: Policy{KeepLast: 5}, want: 0},
+		{name: "daily", policy: Policy{KeepDaily: 7}, want: 7 * day},
+		{name: "weekly", policy: Policy{KeepWeekly: 4}, want: 28 * day},
+		{name: "monthly", policy: Policy{KeepMonthly: 12}, want: 360 * day},
+		{name: "yearly", policy: Policy{KeepYearly: 2}, want: 730 * day},
+		{name: "monthly outlasts daily", policy: Policy{KeepDaily: 7, KeepMonthly: 12}, want: 360 * day},
+		{name: "longest span wins over coarsest rule", policy: Policy{KeepDaily: 90, KeepWeekly: 4}, want: 90 * day},
+		{name: "every rule", policy: Policy{KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 12, KeepYearly: 3}, want: 3 * 365 * day},
+		{name: "capped at max age", policy: Policy{KeepDaily: 7, KeepMonthly: 12, MaxAge: 90 * day}, want: 90 * day},
+		{name: "max age above rules", policy: Policy{KeepDaily: 7, MaxAge: 30 * day}, want: 7 * day},
+		{name: "max age alone", policy: Policy{MaxAge: 30 * day}, want: 30 * day},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.LockPeriod(); got != tt.want {
+				t.Errorf("LockPeriod() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Error classes that can be named in a retry policy
+const (
+	ClassNetwork            = "network"              // Refused or reset connections, DNS failures, broken pipes
+	ClassTimeout            = "timeout"              // Deadlines and I/O timeouts
+	ClassTooManyConnections = "too_many_connections" // The database is out of connection slots
+	ClassThrottled          = "throttled"            // The storage service asked us to slow down or is unavailable
+
+	// ClassAny matches every error, including ones that can't succeed on
+	// a second attempt such as bad credentials
+	ClassAny = "any"
+)
+
+// Classes lists every error class
+var Classes = []string{ClassNetwork, ClassTimeout, ClassTooManyConnections, ClassThrottled}
+
+// Messages that identify an error class when all we have is text, such as
+// the stderr a dump tool printed before exiting
+var classMessages = map[string][]string{
+	ClassNetwork: {
+		"connection refused",
+		"connection reset",
+		"broken pipe",
+		"no route to host",
+		"network is unreachable",
+		"could not connect to server",
+		"can't connect to mysql server",
+		"lost connection to mysql server",
+		"server closed the connection unexpectedly",
+		"no such host",
+	},
+	ClassTimeout: {
+		"timeout",
+		"timed out",
+		"deadline exceeded",
+	},
+	ClassTooManyConnections: {
+		"too many connections",
+		"too many clients",
+		"remaining connection slots are reserved",
+	},
+	ClassThrottled: {
+		"slowdown",
+		"slow down",
+		"throttl",
+		"service unavailable",
+		"try again",
+	},
+}
+
+// Storage service error codes (as returned by the AWS SDK's Code method)
+// that mean the request may succeed if repeated
+var throttledCodes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"ServiceUnavailable":       true,
+	"InternalError":            true,
+	"RequestTimeout":           true,
+	"TooManyRequestsException": true,
+}
+
+// Classify returns the classes an error belongs to, if any
+func Classify(err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	matched := make(map[string]bool)
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		matched[ClassTimeout] = true
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		matched[ClassNetwork] = true
+	}
+
+	var coded interface{ Code() string }
+	if errors.As(err, &coded) && throttledCodes[coded.Code()] {
+		matched[ClassThrottled] = true
+	}
+	var status interface{ StatusCode() int }
+	if errors.As(err, &status) && (status.StatusCode() == 429 || status.StatusCode() >= 500) {
+		matched[ClassThrottled] = true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for class, patterns := range classMessages {
+		for _, pattern := range patterns {
+			if strings.Contains(msg, pattern) {
+				matched[class] = true
+				break
+			}
+		}
+	}
+
+	var classes []string
+	for _, class := range Classes {
+		if matched[class] {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+// RetryableClasses returns a Retryable func accepting errors in any of the
+// given classes. With no classes every known class is retried.
+func RetryableClasses(classes []string) (func(error) bool, error) {
+	if len(classes) == 0 {
+		classes = Classes
+	}
+
+	wanted := make(map[string]bool, len(classes))
+	for _, class := range classes {
+		if class == ClassAny {
+			return nil, nil
+		}
+		known := false
+		for _, c := range Classes {
+			known = known || c == class
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown error class %q (expected %s or %s)", class, strings.Join(Classes, ", "), ClassAny)
+		}
+		wanted[class] = true
+	}
+
+	return func(err error) bool {
+		for _, class := range Classify(err) {
+			if wanted[class] {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
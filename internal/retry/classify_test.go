@@ -0,0 +1,105 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// codedError is a storage error carrying a service error code, like the AWS
+// SDK's awserr.Error
+type codedError struct{ code string }
+
+func (e codedError) Error() string { return "request failed" }
+func (e codedError) Code() string  { return e.code }
+
+// statusError is a storage error carrying an HTTP status
+type statusError struct{ status int }
+
+func (e statusError) Error() string   { return fmt.Sprintf("request failed with status %d", e.status) }
+func (e statusError) StatusCode() int { return e.status }
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{name: "nil", err: nil},
+		{name: "unknown", err: errors.New("permission denied")},
+		{name: "refused", err: fmt.Errorf("connecting: %w", syscall.ECONNREFUSED), want: []string{ClassNetwork}},
+		{name: "dial error", err: &net.OpError{Op: "dial", Err: errors.New("boom")}, want: []string{ClassNetwork}},
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "db"}, want: []string{ClassNetwork}},
+		{name: "unexpected eof", err: fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), want: []string{ClassNetwork}},
+		{name: "deadline", err: fmt.Errorf("dumping: %w", context.DeadlineExceeded), want: []string{ClassTimeout}},
+		{name: "io timeout", err: os.ErrDeadlineExceeded, want: []string{ClassTimeout}},
+		{name: "dump tool stderr", err: errors.New("pg_dump: error: could not connect to server: Connection refused"), want: []string{ClassNetwork}},
+		{name: "postgres slots", err: errors.New("FATAL: remaining connection slots are reserved for superuser"), want: []string{ClassTooManyConnections}},
+		{name: "mysql connections", err: errors.New("Error 1040: Too many connections"), want: []string{ClassTooManyConnections}},
+		{name: "service code", err: fmt.Errorf("uploading: %w", codedError{"SlowDown"}), want: []string{ClassThrottled}},
+		{name: "other service code", err: codedError{"AccessDenied"}},
+		{name: "too many requests", err: statusError{429}, want: []string{ClassThrottled}},
+		{name: "server error", err: statusError{503}, want: []string{ClassThrottled}},
+		{name: "client error", err: statusError{403}},
+		{name: "several classes", err: errors.New("dial tcp: i/o timeout: connection reset by peer"), want: []string{ClassNetwork, ClassTimeout}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); !slices.Equal(got, tt.want) {
+				t.Errorf("Classify(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryableClasses(t *testing.T) {
+	network := errors.New("connection refused")
+	throttled := statusError{503}
+	fatal := errors.New("password authentication failed")
+
+	tests := []struct {
+		name    string
+		classes []string
+		retried []error
+		kept    []error // errors that aren't retried
+		wantErr bool
+	}{
+		{name: "every known class by default", retried: []error{network, throttled}, kept: []error{fatal}},
+		{name: "only network", classes: []string{ClassNetwork}, retried: []error{network}, kept: []error{throttled, fatal}},
+		{name: "any", classes: []string{ClassThrottled, ClassAny}, retried: []error{network, throttled, fatal}},
+		{name: "unknown class", classes: []string{"network", "disk_full"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryable, err := RetryableClasses(tt.classes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("RetryableClasses() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy := Policy{Retryable: retryable}
+			for _, err := range tt.retried {
+				if policy.Retryable != nil && !policy.Retryable(err) {
+					t.Errorf("%v isn't retried", err)
+				}
+			}
+			for _, err := range tt.kept {
+				if policy.Retryable == nil || policy.Retryable(err) {
+					t.Errorf("%v is retried", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// Package retry runs operations again after transient failures, waiting an
+// exponentially growing, jittered interval between attempts.
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy controls how often and how patiently an operation is retried
+type Policy struct {
+	MaxAttempts    int           // Total attempts including the first; below 2 disables retries
+	InitialBackoff time.Duration // Wait before the second attempt
+	MaxBackoff     time.Duration // Upper bound on any single wait; zero means no bound
+	Multiplier     float64       // Growth of the wait per attempt; below 1 means 2
+	Jitter         float64       // Fraction of each wait randomized, e.g. 0.2 for ±20%
+
+	// Retryable decides whether an error is worth another attempt. Nil
+	// retries every error.
+	Retryable func(error) bool
+}
+
+// Backoff returns how long to wait after the given failed attempt (1-based)
+func (p Policy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	wait := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(wait)
+}
+
+// Do runs op until it succeeds, fails with an error that isn't retryable, or
+// the policy's attempts are used up. onRetry, if set, is called before each
+// wait. Nothing is retried once ctx is done. Do returns the number of
+// attempts made and op's last error.
+func Do(ctx context.Context, p Policy, op func() error, onRetry func(attempt int, err error, wait time.Duration)) (int, error) {
+	attempt := 1
+	for {
+		err := op()
+		if err == nil || ctx.Err() != nil || attempt >= p.MaxAttempts || (p.Retryable != nil && !p.Retryable(err)) {
+			return attempt, err
+		}
+
+		wait := p.Backoff(attempt)
+		if onRetry != nil {
+			onRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+		attempt++
+	}
+}
@@ -0,0 +1,122 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first wait", policy: Policy{InitialBackoff: time.Second}, attempt: 1, want: time.Second},
+		{name: "doubles by default", policy: Policy{InitialBackoff: time.Second}, attempt: 4, want: 8 * time.Second},
+		{name: "multiplier", policy: Policy{InitialBackoff: time.Second, Multiplier: 3}, attempt: 3, want: 9 * time.Second},
+		{name: "multiplier below 1", policy: Policy{InitialBackoff: time.Second, Multiplier: 0.5}, attempt: 2, want: 2 * time.Second},
+		{name: "capped", policy: Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, attempt: 10, want: 5 * time.Second},
+		{name: "uncapped", policy: Policy{InitialBackoff: time.Second}, attempt: 11, want: 1024 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Backoff(tt.attempt); got != tt.want {
+				t.Errorf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := Policy{InitialBackoff: 10 * time.Second, Jitter: 0.2}
+	for range 100 {
+		if got := p.Backoff(1); got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("Backoff(1) = %s, want within 20%% of 10s", got)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	errTransient := errors.New("connection reset")
+	errFatal := errors.New("password authentication failed")
+	onlyTransient := func(err error) bool { return errors.Is(err, errTransient) }
+
+	tests := []struct {
+		name         string
+		policy       Policy
+		errs         []error // returned by successive attempts; nil after the last
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "first attempt succeeds", policy: Policy{MaxAttempts: 3}, wantAttempts: 1},
+		{name: "succeeds on retry", policy: Policy{MaxAttempts: 3}, errs: []error{errTransient, errTransient}, wantAttempts: 3},
+		{name: "attempts used up", policy: Policy{MaxAttempts: 3}, errs: []error{errTransient, errTransient, errTransient, errTransient}, wantAttempts: 3, wantErr: errTransient},
+		{name: "retries disabled", policy: Policy{MaxAttempts: 1}, errs: []error{errTransient}, wantAttempts: 1, wantErr: errTransient},
+		{name: "not retryable", policy: Policy{MaxAttempts: 5, Retryable: onlyTransient}, errs: []error{errTransient, errFatal}, wantAttempts: 2, wantErr: errFatal},
+		{name: "nil retryable retries anything", policy: Policy{MaxAttempts: 5}, errs: []error{errFatal}, wantAttempts: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.InitialBackoff = time.Millisecond
+			calls, retries := 0, 0
+			attempts, err := Do(context.Background(), tt.policy, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			}, func(attempt int, err error, wait time.Duration) {
+				retries++
+				if attempt != retries || err == nil || wait <= 0 {
+					t.Errorf("onRetry(%d, %v, %s) on retry %d", attempt, err, wait, retries)
+				}
+			})
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts || calls != tt.wantAttempts {
+				t.Errorf("Do() = %d attempts (%d calls), want %d", attempts, calls, tt.wantAttempts)
+			}
+			if retries != tt.wantAttempts-1 {
+				t.Errorf("onRetry called %d times, want %d", retries, tt.wantAttempts-1)
+			}
+		})
+	}
+}
+
+func TestDoCancelled(t *testing.T) {
+	errTransient := errors.New("connection reset")
+
+	t.Run("during the wait", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		policy := Policy{MaxAttempts: 5, InitialBackoff: time.Hour}
+
+		start := time.Now()
+		attempts, err := Do(ctx, policy, func() error { return errTransient }, func(int, error, time.Duration) { cancel() })
+		if attempts != 1 || !errors.Is(err, errTransient) {
+			t.Errorf("Do() = %d, %v, want 1 attempt and the op's error", attempts, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Minute {
+			t.Errorf("Do() waited %s after cancellation", elapsed)
+		}
+	})
+
+	t.Run("by the op", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		policy := Policy{MaxAttempts: 5, InitialBackoff: time.Millisecond}
+
+		attempts, err := Do(ctx, policy, func() error {
+			cancel()
+			return errTransient
+		}, nil)
+		if attempts != 1 || !errors.Is(err, errTransient) {
+			t.Errorf("Do() = %d, %v, want 1 attempt and the op's error", attempts, err)
+		}
+	})
+}
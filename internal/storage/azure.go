@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
+)
+
+// Streamed uploads are staged as blocks of azureBlockSize, azureConcurrency
+// at a time. A block blob holds at most 50,000 blocks, so this allows
+// streams of up to about 390 GiB while buffering 32 MiB.
+const (
+	azureBlockSize   = 8 << 20
+	azureConcurrency = 4
+)
+
+// Azure stores backups as block blobs in an Azure Blob Storage container,
+// named by Bucket. It authenticates with a connection string, a shared key
+// or a SAS token, in that order of preference.
+type Azure struct {
+	Config Config
+	client *azblob.Client
+}
+
+func NewAzure(cfg Config) (*Azure, error) {
+	serviceURL := cfg.Endpoint
+	if serviceURL == "" && cfg.AccountName != "" {
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", cfg.AccountName)
+	}
+
+	var client *azblob.Client
+	var err error
+	switch {
+	case cfg.ConnectionString != "":
+		client, err = azblob.NewClientFromConnectionString(cfg.ConnectionString, nil)
+	case serviceURL == "":
+		return nil, fmt.Errorf("azure storage needs a connection string, an account name or an endpoint")
+	case cfg.AccountKey != "":
+		var cred *azblob.SharedKeyCredential
+		cred, err = azblob.NewSharedKeyCredential(cfg.AccountName, cfg.AccountKey)
+		if err == nil {
+			client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+		}
+	case cfg.SASToken != "":
+		client, err = azblob.NewClientWithNoCredential(serviceURL+"?"+strings.TrimPrefix(cfg.SASToken, "?"), nil)
+	default:
+		return nil, fmt.Errorf("azure storage needs a connection string, an account key or a SAS token")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %w", err)
+	}
+	return &Azure{Config: cfg, client: client}, nil
+}
+
+// key combines BasePath and remotePath if BasePath is set (as prefix)
+func (a *Azure) key(remotePath string) string {
+	if a.Config.BasePath == "" {
+		return remotePath
+	}
+	return fmt.Sprintf("%s/%s", a.Config.BasePath, remotePath)
+}
+
+// relativeKey strips the BasePath prefix from a blob name
+func (a *Azure) relativeKey(name string) string {
+	if a.Config.BasePath == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, a.Config.BasePath+"/")
+}
+
+// blobOptions returns the access tier, blob index tags and metadata for an
+// upload. Configured tags win over the per-object ones.
+func (a *Azure) blobOptions(opts UploadOptions) (*blob.AccessTier, map[string]string, map[string]*string) {
+	var tier *blob.AccessTier
+	if a.Config.StorageClass != "" {
+		t := blob.AccessTier(a.Config.StorageClass)
+		tier = &t
+	}
+
+	var tags map[string]string
+	if len(opts.Tags) > 0 || len(a.Config.Tags) > 0 {
+		tags = make(map[string]string, len(opts.Tags)+len(a.Config.Tags))
+		for k, v := range opts.Tags {
+			tags[k] = v
+		}
+		for k, v := range a.Config.Tags {
+			tags[k] = v
+		}
+	}
+
+	// Metadata names must be valid C# identifiers
+	var metadata map[string]*string
+	if len(opts.Metadata) > 0 {
+		metadata = make(map[string]*string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			metadata[strings.ReplaceAll(k, "-", "_")] = &v
+		}
+	}
+	return tier, tags, metadata
+}
+
+func (a *Azure) Upload(ctx context.Context, localPath string, remotePath string, opts UploadOptions) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", localPath, err)
+	}
+	defer f.Close()
+
+	tier, tags, metadata := a.blobOptions(opts)
+	_, err = a.client.UploadFile(ctx, a.Config.Bucket, a.key(remotePath), f, &azblob.UploadFileOptions{
+		AccessTier: tier,
+		Tags:       tags,
+		Metadata:   metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Azure) Download(ctx context.Context, remotePath string, localPath string) error {
+	f, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file %q: %w", localPath, err)
+	}
+	defer f.Close()
+
+	if _, err := a.client.DownloadFile(ctx, a.Config.Bucket, a.key(remotePath), f, nil); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	return nil
+}
+
+// StreamUpload stages the stream as blocks and commits them once it ends,
+// so a failed upload never leaves a partial blob behind
+func (a *Azure) StreamUpload(ctx context.Context, reader io.Reader, remotePath string, opts UploadOptions) error {
+	tier, tags, metadata := a.blobOptions(opts)
+	_, err := a.client.UploadStream(ctx, a.Config.Bucket, a.key(remotePath), reader, &azblob.UploadStreamOptions{
+		BlockSize:   azureBlockSize,
+		Concurrency: azureConcurrency,
+		AccessTier:  tier,
+		Tags:        tags,
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload stream: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Azure) StreamDownload(ctx context.Context, remotePath string, writer io.Writer) error {
+	resp, err := a.client.DownloadStream(ctx, a.Config.Bucket, a.key(remotePath), nil)
+	if err != nil {
+		if isAzureNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to download blob: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := ctxio.Copy(ctx, writer, resp.Body); err != nil {
+		return fmt.Errorf("failed to download blob: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Azure) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	listOpts := &azblob.ListBlobsFlatOptions{Prefix: to.Ptr(a.key(opts.Prefix))}
+	if opts.MaxKeys > 0 {
+		listOpts.MaxResults = to.Ptr(int32(opts.MaxKeys))
+	}
+	if opts.PageToken != "" {
+		listOpts.Marker = to.Ptr(opts.PageToken)
+	}
+
+	page, err := a.client.NewListBlobsFlatPager(a.Config.Bucket, listOpts).NextPage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	result := &ListResult{}
+	if page.Segment != nil {
+		for _, item := range page.Segment.BlobItems {
+			info := ObjectInfo{Key: a.relativeKey(deref(item.Name))}
+			if props := item.Properties; props != nil {
+				info.Size = deref(props.ContentLength)
+				info.ModTime = deref(props.LastModified)
+			}
+			result.Objects = append(result.Objects, info)
+		}
+	}
+	result.NextPageToken = deref(page.NextMarker)
+
+	return result, nil
+}
+
+func (a *Azure) Delete(ctx context.Context, remotePath string) error {
+	if _, err := a.client.DeleteBlob(ctx, a.Config.Bucket, a.key(remotePath), nil); err != nil {
+		if isAzureNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Azure) Stat(ctx context.Context, remotePath string) (*ObjectInfo, error) {
+	blobClient := a.client.ServiceClient().NewContainerClient(a.Config.Bucket).NewBlobClient(a.key(remotePath))
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		if isAzureNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat blob: %w", err)
+	}
+
+	return azureObjectInfo(remotePath, props), nil
+}
+
+// azureObjectInfo describes the blob at remotePath from its properties
+func azureObjectInfo(remotePath string, props blob.GetPropertiesResponse) *ObjectInfo {
+	return &ObjectInfo{
+		Key:         remotePath,
+		Size:        deref(props.ContentLength),
+		ModTime:     deref(props.LastModified),
+		RetainUntil: deref(props.ImmutabilityPolicyExpiresOn),
+		LegalHold:   deref(props.LegalHold),
+	}
+}
+
+func (a *Azure) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := a.Stat(ctx, remotePath)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isAzureNotFound reports whether err is a 404. Responses to HEAD requests
+// have no body, so they carry no error code to check instead.
+func isAzureNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// deref returns the value p points to, or the zero value if p is nil
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
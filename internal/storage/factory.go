@@ -10,6 +10,10 @@ func NewStorage(cfg Config) (Storage, error) {
 		return NewLocal(cfg), nil
 	case "s3", "aws":
 		return NewS3(cfg)
+	case "gcs", "gs":
+		return NewGCS(cfg)
+	case "azure", "azblob":
+		return NewAzure(cfg)
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Type)
 	}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// GCS stores backups in a Google Cloud Storage bucket. Without a
+// credentials file it uses Application Default Credentials, which covers
+// workload identity on GKE and the service account of a GCE instance. It
+// talks to an emulator such as fake-gcs-server when STORAGE_EMULATOR_HOST
+// is set.
+type GCS struct {
+	Config Config
+	client *gcs.Client
+	bucket *gcs.BucketHandle
+}
+
+func NewGCS(cfg Config) (*GCS, error) {
+	var opts []option.ClientOption
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
+	}
+	if cfg.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.Endpoint))
+	}
+
+	client, err := gcs.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &GCS{Config: cfg, client: client, bucket: client.Bucket(cfg.Bucket)}, nil
+}
+
+// key combines BasePath and remotePath if BasePath is set (as prefix)
+func (g *GCS) key(remotePath string) string {
+	if g.Config.BasePath == "" {
+		return remotePath
+	}
+	return fmt.Sprintf("%s/%s", g.Config.BasePath, remotePath)
+}
+
+// relativeKey strips the BasePath prefix from an object name
+func (g *GCS) relativeKey(name string) string {
+	if g.Config.BasePath == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, g.Config.BasePath+"/")
+}
+
+func (g *GCS) Upload(ctx context.Context, localPath string, remotePath string, opts UploadOptions) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", localPath, err)
+	}
+	defer f.Close()
+
+	if err := g.write(ctx, f, remotePath, opts); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+func (g *GCS) Download(ctx context.Context, remotePath string, localPath string) error {
+	f, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file %q: %w", localPath, err)
+	}
+	defer f.Close()
+
+	if err := g.StreamDownload(ctx, remotePath, f); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	return nil
+}
+
+func (g *GCS) StreamUpload(ctx context.Context, reader io.Reader, remotePath string, opts UploadOptions) error {
+	if err := g.write(ctx, reader, remotePath, opts); err != nil {
+		return fmt.Errorf("failed to upload stream: %w", err)
+	}
+	return nil
+}
+
+// write uploads reader as a resumable upload. The object only appears once
+// all of it has been written, so a failed upload leaves nothing behind.
+func (g *GCS) write(ctx context.Context, reader io.Reader, remotePath string, opts UploadOptions) error {
+	// Cancelling the writer's context is the only way to abort the upload
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.bucket.Object(g.key(remotePath)).NewWriter(ctx)
+	w.Metadata = opts.Metadata
+	w.StorageClass = g.Config.StorageClass
+	w.KMSKeyName = g.Config.KMSKeyID
+
+	if _, err := ctxio.Copy(ctx, w, reader); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (g *GCS) StreamDownload(ctx context.Context, remotePath string, writer io.Writer) error {
+	r, err := g.bucket.Object(g.key(remotePath)).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to download object: %w", err)
+	}
+	defer r.Close()
+
+	if _, err := ctxio.Copy(ctx, writer, r); err != nil {
+		return fmt.Errorf("failed to download object: %w", err)
+	}
+
+	return nil
+}
+
+func (g *GCS) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	query := &gcs.Query{Prefix: g.key(opts.Prefix)}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated"}); err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+
+	var attrs []*gcs.ObjectAttrs
+	pager := iterator.NewPager(g.bucket.Objects(ctx, query), maxKeys, opts.PageToken)
+	next, err := pager.NextPage(&attrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	result := &ListResult{NextPageToken: next}
+	for _, a := range attrs {
+		result.Objects = append(result.Objects, ObjectInfo{
+			Key:     g.relativeKey(a.Name),
+			Size:    a.Size,
+			ModTime: a.Updated,
+		})
+	}
+
+	return result, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, remotePath string) error {
+	if err := g.bucket.Object(g.key(remotePath)).Delete(ctx); err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	return nil
+}
+
+func (g *GCS) Stat(ctx context.Context, remotePath string) (*ObjectInfo, error) {
+	attrs, err := g.bucket.Object(g.key(remotePath)).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	return gcsObjectInfo(remotePath, attrs), nil
+}
+
+// gcsObjectInfo describes the object at remotePath from its attributes
+func gcsObjectInfo(remotePath string, attrs *gcs.ObjectAttrs) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:     remotePath,
+		Size:    attrs.Size,
+		ModTime: attrs.Updated,
+		// A bucket retention policy and object retention both prevent deletes
+		RetainUntil: attrs.RetentionExpirationTime,
+		LegalHold:   attrs.TemporaryHold || attrs.EventBasedHold,
+	}
+	if attrs.Retention != nil && attrs.Retention.RetainUntil.After(info.RetainUntil) {
+		info.RetainUntil = attrs.Retention.RetainUntil
+	}
+	return info
+}
+
+func (g *GCS) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := g.Stat(ctx, remotePath)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
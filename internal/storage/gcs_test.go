@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// newTestGCS returns a GCS backend on a fresh bucket in the emulator named
+// by STORAGE_EMULATOR_HOST, such as fake-gcs-server:
+//
+//	fake-gcs-server -scheme http -port 4443
+//	STORAGE_EMULATOR_HOST=localhost:4443 go test ./internal/storage
+func newTestGCS(t *testing.T) *GCS {
+	t.Helper()
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("STORAGE_EMULATOR_HOST not set; run fake-gcs-server to test the GCS backend")
+	}
+
+	bucket := fmt.Sprintf("backyard-test-%d", time.Now().UnixNano())
+	store, err := NewGCS(Config{Type: "gcs", Bucket: bucket, BasePath: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.bucket.Create(context.Background(), "backyard-test", nil); err != nil {
+		t.Fatalf("creating bucket %s: %v", bucket, err)
+	}
+	t.Cleanup(func() { store.client.Close() })
+	return store
+}
+
+func TestGCSUploadDownload(t *testing.T) {
+	store := newTestGCS(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "app.sql.gz")
+	if err := os.WriteFile(src, []byte("file contents"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := UploadOptions{Metadata: map[string]string{"sha256": "abc"}}
+	if err := store.Upload(ctx, src, "app.sql.gz", opts); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := store.StreamUpload(ctx, strings.NewReader("stream contents"), "stream.sql.gz", opts); err != nil {
+		t.Fatalf("StreamUpload: %v", err)
+	}
+
+	dst := filepath.Join(dir, "downloaded")
+	if err := store.Download(ctx, "app.sql.gz", dst); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "file contents" {
+		t.Errorf("Download wrote %q", got)
+	}
+
+	var buf strings.Builder
+	if err := store.StreamDownload(ctx, "stream.sql.gz", &buf); err != nil {
+		t.Fatalf("StreamDownload: %v", err)
+	}
+	if buf.String() != "stream contents" {
+		t.Errorf("StreamDownload wrote %q", buf.String())
+	}
+
+	// Objects land under the base path, with their metadata
+	attrs, err := store.bucket.Object("db/stream.sql.gz").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Metadata["sha256"] != "abc" {
+		t.Errorf("metadata %v, want sha256=abc", attrs.Metadata)
+	}
+}
+
+func TestGCSListPagination(t *testing.T) {
+	store := newTestGCS(t)
+	ctx := context.Background()
+
+	want := []string{"a.sql.gz", "b.sql.gz", "c.sql.gz", "d.sql.gz", "e.sql.gz"}
+	for _, key := range want {
+		if err := store.StreamUpload(ctx, strings.NewReader(key), key, UploadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	pages := 0
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := store.List(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, obj := range page.Objects {
+			got = append(got, obj.Key)
+			if obj.Size != int64(len(obj.Key)) || obj.ModTime.IsZero() {
+				t.Errorf("%s: size %d, mod time %s", obj.Key, obj.Size, obj.ModTime)
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+
+	all, err := ListAll(ctx, store, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Key != "c.sql.gz" {
+		t.Errorf("ListAll(c) = %v, want c.sql.gz", all)
+	}
+
+	// fake-gcs-server (as of v1.50) ignores maxResults and returns one page
+	if pages == 1 {
+		t.Skip("emulator doesn't paginate listings")
+	}
+	if pages != 3 {
+		t.Errorf("listed in %d pages, want 3", pages)
+	}
+}
+
+func TestGCSStatDeleteExists(t *testing.T) {
+	store := newTestGCS(t)
+	ctx := context.Background()
+
+	if err := store.StreamUpload(ctx, strings.NewReader("dump"), "app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := store.Stat(ctx, "app.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "app.sql.gz" || info.Size != 4 || info.Locked(time.Now()) {
+		t.Errorf("Stat() = %+v, want an unlocked 4-byte app.sql.gz", info)
+	}
+	if ok, err := store.Exists(ctx, "app.sql.gz"); !ok || err != nil {
+		t.Errorf("Exists() = %t, %v, want true, nil", ok, err)
+	}
+
+	if err := store.Delete(ctx, "app.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Exists(ctx, "app.sql.gz"); ok || err != nil {
+		t.Errorf("Exists() after Delete = %t, %v, want false, nil", ok, err)
+	}
+	if _, err := store.Stat(ctx, "app.sql.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "app.sql.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := store.StreamDownload(ctx, "app.sql.gz", &strings.Builder{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("StreamDownload() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGCSStatHold(t *testing.T) {
+	store := newTestGCS(t)
+	ctx := context.Background()
+
+	if err := store.StreamUpload(ctx, strings.NewReader("dump"), "app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	obj := store.bucket.Object("db/app.sql.gz")
+	attrs, err := obj.Update(ctx, gcs.ObjectAttrsToUpdate{TemporaryHold: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attrs.TemporaryHold {
+		t.Skip("emulator doesn't support object holds")
+	}
+	t.Cleanup(func() { obj.Update(context.Background(), gcs.ObjectAttrsToUpdate{TemporaryHold: false}) })
+
+	info, err := store.Stat(ctx, "app.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.LegalHold || !info.Locked(time.Now()) {
+		t.Errorf("Stat() = %+v, want a legal hold", info)
+	}
+}
+
+func TestGCSStatRetention(t *testing.T) {
+	store := newTestGCS(t)
+	ctx := context.Background()
+
+	if _, err := store.bucket.Update(ctx, gcs.BucketAttrsToUpdate{
+		RetentionPolicy: &gcs.RetentionPolicy{RetentionPeriod: time.Hour},
+	}); err != nil {
+		t.Skipf("emulator doesn't support retention policies: %v", err)
+	}
+
+	if err := store.StreamUpload(ctx, strings.NewReader("dump"), "app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := store.bucket.Object("db/app.sql.gz").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.RetentionExpirationTime.IsZero() {
+		t.Skip("emulator doesn't apply bucket retention policies to objects")
+	}
+
+	info, err := store.Stat(ctx, "app.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.RetainUntil.Equal(attrs.RetentionExpirationTime) || !info.Locked(time.Now()) {
+		t.Errorf("Stat() retain until %s, want %s", info.RetainUntil, attrs.RetentionExpirationTime)
+	}
+}
+
+// TestGCSObjectInfo covers the retention and holds Stat reports, which
+// fake-gcs-server doesn't implement
+func TestGCSObjectInfo(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	policy := now.Add(24 * time.Hour)
+	object := now.Add(48 * time.Hour)
+
+	tests := []struct {
+		name        string
+		attrs       gcs.ObjectAttrs
+		retainUntil time.Time
+		legalHold   bool
+	}{
+		{name: "unprotected", attrs: gcs.ObjectAttrs{}},
+		{name: "bucket retention policy", attrs: gcs.ObjectAttrs{RetentionExpirationTime: policy}, retainUntil: policy},
+		{name: "object retention", attrs: gcs.ObjectAttrs{Retention: &gcs.ObjectRetention{Mode: "Locked", RetainUntil: object}}, retainUntil: object},
+		{
+			name:        "later of both",
+			attrs:       gcs.ObjectAttrs{RetentionExpirationTime: object, Retention: &gcs.ObjectRetention{RetainUntil: policy}},
+			retainUntil: object,
+		},
+		{name: "temporary hold", attrs: gcs.ObjectAttrs{TemporaryHold: true}, legalHold: true},
+		{name: "event-based hold", attrs: gcs.ObjectAttrs{EventBasedHold: true}, legalHold: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.attrs.Size = 4
+			tt.attrs.Updated = now
+			info := gcsObjectInfo("app.sql.gz", &tt.attrs)
+			if info.Key != "app.sql.gz" || info.Size != 4 || !info.ModTime.Equal(now) {
+				t.Errorf("gcsObjectInfo() = %+v", info)
+			}
+			if !info.RetainUntil.Equal(tt.retainUntil) || info.LegalHold != tt.legalHold {
+				t.Errorf("retain until %s, legal hold %t; want %s, %t", info.RetainUntil, info.LegalHold, tt.retainUntil, tt.legalHold)
+			}
+			if locked := !tt.retainUntil.IsZero() || tt.legalHold; info.Locked(now) != locked {
+				t.Errorf("Locked() = %t, want %t", info.Locked(now), locked)
+			}
+		})
+	}
+}
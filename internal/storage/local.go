@@ -1,12 +1,23 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
 )
 
+// defaultMaxKeys is the List page size used when none is requested
+const defaultMaxKeys = 1000
+
 type Local struct {
 	Config Config
 }
@@ -15,7 +26,7 @@ func NewLocal(cfg Config) *Local {
 	return &Local{Config: cfg}
 }
 
-func (l *Local) Upload(localPath string, remotePath string) error {
+func (l *Local) Upload(ctx context.Context, localPath string, remotePath string, opts UploadOptions) error {
 	// In local storage, remotePath is relative to the BasePath in config
 	destPath := filepath.Join(l.Config.BasePath, remotePath)
 
@@ -31,24 +42,43 @@ func (l *Local) Upload(localPath string, remotePath string) error {
 	}
 	defer srcFile.Close()
 
-	destFile, err := os.Create(destPath)
+	destFile, err := l.create(destPath)
 	if err != nil {
 		return err
 	}
 	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
+	if _, err := ctxio.Copy(ctx, destFile, srcFile); err != nil {
+		// A partial copy would block a retry in WORM mode
+		destFile.Close()
+		os.Remove(destPath)
 		return err
 	}
 
 	return nil
 }
 
-func (l *Local) Download(remotePath string, localPath string) error {
+// create opens a new file at destPath for writing. In WORM mode the file is
+// created read-only and must not exist yet.
+func (l *Local) create(destPath string) (*os.File, error) {
+	if !l.Config.WORM {
+		return os.Create(destPath)
+	}
+	f, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%s already exists and write-once storage won't overwrite it", destPath)
+	}
+	return f, err
+}
+
+func (l *Local) Download(ctx context.Context, remotePath string, localPath string) error {
 	srcPath := filepath.Join(l.Config.BasePath, remotePath)
 
 	srcFile, err := os.Open(srcPath)
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
 		return err
 	}
 	defer srcFile.Close()
@@ -59,29 +89,164 @@ func (l *Local) Download(remotePath string, localPath string) error {
 	}
 	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
+	if _, err := ctxio.Copy(ctx, destFile, srcFile); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (l *Local) StreamUpload(reader io.Reader, remotePath string) error {
+func (l *Local) StreamUpload(ctx context.Context, reader io.Reader, remotePath string, opts UploadOptions) error {
 	destPath := filepath.Join(l.Config.BasePath, remotePath)
 
 	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		return err
 	}
 
-	destFile, err := os.Create(destPath)
+	destFile, err := l.create(destPath)
 	if err != nil {
 		return err
 	}
 	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, reader); err != nil {
+	if _, err := ctxio.Copy(ctx, destFile, reader); err != nil {
+		// Don't leave a truncated backup behind if the stream was aborted
+		destFile.Close()
+		os.Remove(destPath)
 		return err
 	}
 
 	return nil
 }
+
+func (l *Local) StreamDownload(ctx context.Context, remotePath string, writer io.Writer) error {
+	srcPath := filepath.Join(l.Config.BasePath, remotePath)
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	defer srcFile.Close()
+
+	if _, err := ctxio.Copy(ctx, writer, srcFile); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	var keys []string
+	err := filepath.WalkDir(l.Config.BasePath, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			// A missing base directory simply means nothing has been stored yet
+			if errors.Is(err, fs.ErrNotExist) && path == l.Config.BasePath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Config.BasePath, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, opts.Prefix) && key > opts.PageToken {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+
+	// WalkDir visits in lexical order per directory, but sort the full
+	// keys so page tokens stay consistent across nested directories
+	sort.Strings(keys)
+
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+
+	result := &ListResult{}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.NextPageToken = keys[len(keys)-1]
+	}
+
+	for _, key := range keys {
+		info, err := l.Stat(ctx, key)
+		if err != nil {
+			// The file may have been removed since the walk
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		result.Objects = append(result.Objects, *info)
+	}
+
+	return result, nil
+}
+
+func (l *Local) Delete(ctx context.Context, remotePath string) error {
+	if l.Config.WORM {
+		info, err := l.Stat(ctx, remotePath)
+		if err != nil {
+			return err
+		}
+		if info.Locked(time.Now()) {
+			return fmt.Errorf("%w until %s", ErrLocked, info.RetainUntil.Format(time.RFC3339))
+		}
+	}
+
+	path := filepath.Join(l.Config.BasePath, remotePath)
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Stat(ctx context.Context, remotePath string) (*ObjectInfo, error) {
+	path := filepath.Join(l.Config.BasePath, remotePath)
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	info := &ObjectInfo{
+		Key:     filepath.ToSlash(remotePath),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+	if l.Config.WORM && l.Config.LockPeriod > 0 {
+		info.RetainUntil = fi.ModTime().Add(l.Config.LockPeriod)
+	}
+	return info, nil
+}
+
+func (l *Local) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := l.Stat(ctx, remotePath)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalUploadDownload(t *testing.T) {
+	store := NewLocal(Config{Type: "local", BasePath: t.TempDir()})
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "app.sql.gz")
+	if err := os.WriteFile(src, []byte("file contents"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Upload(ctx, src, "nightly/app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := store.StreamUpload(ctx, strings.NewReader("streamed"), "stream.sql.gz", UploadOptions{}); err != nil {
+		t.Fatalf("StreamUpload: %v", err)
+	}
+
+	dst := filepath.Join(dir, "downloaded")
+	if err := store.Download(ctx, "nightly/app.sql.gz", dst); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "file contents" {
+		t.Errorf("Download wrote %q", got)
+	}
+
+	var buf strings.Builder
+	if err := store.StreamDownload(ctx, "stream.sql.gz", &buf); err != nil {
+		t.Fatalf("StreamDownload: %v", err)
+	}
+	if buf.String() != "streamed" {
+		t.Errorf("StreamDownload wrote %q", buf.String())
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if err := store.Download(ctx, "missing.sql.gz", missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download() error = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Download of a missing object created %s", missing)
+	}
+	if err := store.StreamDownload(ctx, "missing.sql.gz", &strings.Builder{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("StreamDownload() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalListPagination(t *testing.T) {
+	store := NewLocal(Config{Type: "local", BasePath: t.TempDir()})
+	ctx := context.Background()
+
+	// Nested keys sort between the top-level ones
+	want := []string{"a.sql.gz", "b/x.sql.gz", "b/y.sql.gz", "c.sql.gz", "d.sql.gz"}
+	for _, key := range want {
+		if err := store.StreamUpload(ctx, strings.NewReader(key), key, UploadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	pages := 0
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := store.List(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, obj := range page.Objects {
+			got = append(got, obj.Key)
+			if obj.Size != int64(len(obj.Key)) || obj.ModTime.IsZero() {
+				t.Errorf("%s: size %d, mod time %s", obj.Key, obj.Size, obj.ModTime)
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+	if pages != 3 {
+		t.Errorf("listed in %d pages, want 3", pages)
+	}
+
+	all, err := ListAll(ctx, store, "b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Key != "b/x.sql.gz" || all[1].Key != "b/y.sql.gz" {
+		t.Errorf("ListAll(b/) = %v, want b/x.sql.gz and b/y.sql.gz", all)
+	}
+}
+
+func TestLocalListMissingBase(t *testing.T) {
+	store := NewLocal(Config{Type: "local", BasePath: filepath.Join(t.TempDir(), "not-yet")})
+
+	page, err := store.List(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 0 || page.NextPageToken != "" {
+		t.Errorf("List() = %+v, want nothing", page)
+	}
+}
+
+func TestLocalStatDeleteExists(t *testing.T) {
+	store := NewLocal(Config{Type: "local", BasePath: t.TempDir()})
+	ctx := context.Background()
+
+	if err := store.StreamUpload(ctx, strings.NewReader("dump"), "nightly/app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := store.Stat(ctx, "nightly/app.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "nightly/app.sql.gz" || info.Size != 4 || info.Locked(time.Now()) {
+		t.Errorf("Stat() = %+v, want an unlocked 4-byte nightly/app.sql.gz", info)
+	}
+	if ok, err := store.Exists(ctx, "nightly/app.sql.gz"); !ok || err != nil {
+		t.Errorf("Exists() = %t, %v, want true, nil", ok, err)
+	}
+	// A directory isn't an object
+	if ok, err := store.Exists(ctx, "nightly"); ok || err != nil {
+		t.Errorf("Exists(nightly) = %t, %v, want false, nil", ok, err)
+	}
+
+	if err := store.Delete(ctx, "nightly/app.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Exists(ctx, "nightly/app.sql.gz"); ok || err != nil {
+		t.Errorf("Exists() after Delete = %t, %v, want false, nil", ok, err)
+	}
+	if _, err := store.Stat(ctx, "nightly/app.sql.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "nightly/app.sql.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalWORM(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	store := NewLocal(Config{Type: "local", BasePath: base, WORM: true, LockPeriod: time.Hour})
+
+	if err := store.StreamUpload(ctx, strings.NewReader("dump"), "app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StreamUpload(ctx, strings.NewReader("again"), "app.sql.gz", UploadOptions{}); err == nil {
+		t.Error("StreamUpload overwrote a write-once backup")
+	}
+
+	info, err := store.Stat(ctx, "app.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Locked(time.Now()) {
+		t.Errorf("Stat() = %+v, want locked for an hour", info)
+	}
+	if err := store.Delete(ctx, "app.sql.gz"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Delete() error = %v, want ErrLocked", err)
+	}
+
+	// Once the lock period has passed the backup can go
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(base, "app.sql.gz"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "app.sql.gz"); err != nil {
+		t.Errorf("Delete() after the lock expired: %v", err)
+	}
+}
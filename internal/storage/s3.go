@@ -1,106 +1,320 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/saurabhdhingra/backyard-backup/internal/ctxio"
 )
 
 type S3 struct {
 	Config Config
 	sess   *session.Session
+	client *s3.S3
 }
 
+// SSECustomer selects server-side encryption with a customer-provided key
+const SSECustomer = "SSE-C"
+
+// defaultS3Region is used with a custom endpoint when no region is set,
+// since the SDK requires one for signing
+const defaultS3Region = "us-east-1"
+
 func NewS3(cfg Config) (*S3, error) {
 	awsConfig := &aws.Config{
 		Region: aws.String(cfg.Region),
 	}
 
 	if cfg.AccessKey != "" && cfg.SecretKey != "" {
-		awsConfig.Credentials = credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, "")
+		awsConfig.Credentials = credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, cfg.SessionToken)
+	}
+
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+		if cfg.Region == "" {
+			awsConfig.Region = aws.String(defaultS3Region)
+		}
+	}
+	awsConfig.S3ForcePathStyle = aws.Bool(cfg.PathStyle)
+
+	switch cfg.SSE {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	case SSECustomer:
+		if len(cfg.SSECustomerKey) != 32 {
+			return nil, fmt.Errorf("SSE-C needs a 32-byte key, got %d bytes", len(cfg.SSECustomerKey))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported server-side encryption %q (use %s, %s or %s)", cfg.SSE, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms, SSECustomer)
+	}
+	if cfg.KMSKeyID != "" && cfg.SSE != s3.ServerSideEncryptionAwsKms {
+		return nil, fmt.Errorf("a KMS key ID requires %s server-side encryption", s3.ServerSideEncryptionAwsKms)
+	}
+	switch cfg.LockMode {
+	case "":
+	case LockGovernance, LockCompliance:
+		if cfg.LockPeriod <= 0 {
+			return nil, fmt.Errorf("object lock needs a retention period")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported object lock mode %q (use %s or %s)", cfg.LockMode, LockGovernance, LockCompliance)
+	}
+
+	opts := session.Options{Config: *awsConfig}
+	if cfg.InsecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		opts.Config.HTTPClient = &http.Client{Transport: transport}
+	}
+	// Takes precedence over AWS_CA_BUNDLE
+	if cfg.CABundle != "" {
+		bundle, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		opts.CustomCABundle = bytes.NewReader(bundle)
 	}
 
-	sess, err := session.NewSession(awsConfig)
+	sess, err := session.NewSessionWithOptions(opts)
 	if err != nil {
 		return nil, err
 	}
-	return &S3{Config: cfg, sess: sess}, nil
+	return &S3{Config: cfg, sess: sess, client: s3.New(sess)}, nil
+}
+
+// key combines BasePath and remotePath if BasePath is set (as prefix)
+func (s *S3) key(remotePath string) string {
+	if s.Config.BasePath == "" {
+		return remotePath
+	}
+	return fmt.Sprintf("%s/%s", s.Config.BasePath, remotePath)
+}
+
+// relativeKey strips the BasePath prefix from an object key
+func (s *S3) relativeKey(key string) string {
+	if s.Config.BasePath == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, s.Config.BasePath+"/")
 }
 
-func (s *S3) Upload(localPath string, remotePath string) error {
+// uploadInput builds the request for an upload, applying the configured
+// encryption, storage class and tags
+func (s *S3) uploadInput(remotePath string, body io.Reader, opts UploadOptions) *s3manager.UploadInput {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(s.key(remotePath)),
+		Body:   body,
+	}
+
+	switch s.Config.SSE {
+	case "":
+	case SSECustomer:
+		input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	default:
+		input.ServerSideEncryption = aws.String(s.Config.SSE)
+		if s.Config.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.Config.KMSKeyID)
+		}
+	}
+	if s.Config.StorageClass != "" {
+		input.StorageClass = aws.String(s.Config.StorageClass)
+	}
+	if s.Config.LockMode != "" {
+		input.ObjectLockMode = aws.String(s.Config.LockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(s.Config.LockPeriod))
+	}
+	if s.Config.LegalHold {
+		input.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	}
+
+	// Configured tags win over the per-object ones
+	tags := url.Values{}
+	for k, v := range opts.Tags {
+		tags.Set(k, v)
+	}
+	for k, v := range s.Config.Tags {
+		tags.Set(k, v)
+	}
+	if len(tags) > 0 {
+		input.Tagging = aws.String(tags.Encode())
+	}
+	if len(opts.Metadata) > 0 {
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+	return input
+}
+
+// customerKey returns the algorithm and key headers for SSE-C, or nils when
+// objects aren't encrypted with a customer key. Reading such an object needs
+// the same key it was written with.
+func (s *S3) customerKey() (algorithm, key *string) {
+	if s.Config.SSE != SSECustomer {
+		return nil, nil
+	}
+	return aws.String(s3.ServerSideEncryptionAes256), aws.String(string(s.Config.SSECustomerKey))
+}
+
+func (s *S3) Upload(ctx context.Context, localPath string, remotePath string, opts UploadOptions) error {
 	f, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to open file %q, %v", localPath, err)
+		return fmt.Errorf("failed to open file %q: %w", localPath, err)
 	}
 	defer f.Close()
 
 	uploader := s3manager.NewUploader(s.sess)
 
-	// Combine BasePath and remotePath if BasePath is set (as prefix)
-	key := remotePath
-	if s.Config.BasePath != "" {
-		key = fmt.Sprintf("%s/%s", s.Config.BasePath, remotePath)
-	}
-
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.Config.Bucket),
-		Key:    aws.String(key),
-		Body:   f,
-	})
+	_, err = uploader.UploadWithContext(ctx, s.uploadInput(remotePath, f, opts))
 	if err != nil {
-		return fmt.Errorf("failed to upload file, %v", err)
+		return fmt.Errorf("failed to upload file: %w", err)
 	}
 
 	return nil
 }
 
-func (s *S3) Download(remotePath string, localPath string) error {
+func (s *S3) Download(ctx context.Context, remotePath string, localPath string) error {
 	f, err := os.Create(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to create file %q, %v", localPath, err)
+		return fmt.Errorf("failed to create file %q: %w", localPath, err)
 	}
 	defer f.Close()
 
 	downloader := s3manager.NewDownloader(s.sess)
 
-	key := remotePath
-	if s.Config.BasePath != "" {
-		key = fmt.Sprintf("%s/%s", s.Config.BasePath, remotePath)
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(s.key(remotePath)),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	_, err = downloader.DownloadWithContext(ctx, f, input)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *S3) StreamUpload(ctx context.Context, reader io.Reader, remotePath string, opts UploadOptions) error {
+	uploader := s3manager.NewUploader(s.sess)
+
+	_, err := uploader.UploadWithContext(ctx, s.uploadInput(remotePath, reader, opts))
+	if err != nil {
+		return fmt.Errorf("failed to upload stream: %w", err)
 	}
 
-	_, err = downloader.Download(f, &s3.GetObjectInput{
+	return nil
+}
+
+func (s *S3) StreamDownload(ctx context.Context, remotePath string, writer io.Writer) error {
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
-		Key:    aws.String(key),
-	})
+		Key:    aws.String(s.key(remotePath)),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	out, err := s.client.GetObjectWithContext(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to download file, %v", err)
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to download object: %w", err)
+	}
+	defer out.Body.Close()
+
+	if _, err := ctxio.Copy(ctx, writer, out.Body); err != nil {
+		return fmt.Errorf("failed to download object: %w", err)
 	}
 
 	return nil
 }
 
-func (s *S3) StreamUpload(reader io.Reader, remotePath string) error {
-	uploader := s3manager.NewUploader(s.sess)
+func (s *S3) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Config.Bucket),
+		Prefix: aws.String(s.key(opts.Prefix)),
+	}
+	if opts.MaxKeys > 0 {
+		input.MaxKeys = aws.Int64(int64(opts.MaxKeys))
+	}
+	if opts.PageToken != "" {
+		input.ContinuationToken = aws.String(opts.PageToken)
+	}
+
+	out, err := s.client.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
 
-	key := remotePath
-	if s.Config.BasePath != "" {
-		key = fmt.Sprintf("%s/%s", s.Config.BasePath, remotePath)
+	result := &ListResult{}
+	for _, obj := range out.Contents {
+		result.Objects = append(result.Objects, ObjectInfo{
+			Key:     s.relativeKey(aws.StringValue(obj.Key)),
+			Size:    aws.Int64Value(obj.Size),
+			ModTime: aws.TimeValue(obj.LastModified),
+		})
+	}
+	if aws.BoolValue(out.IsTruncated) {
+		result.NextPageToken = aws.StringValue(out.NextContinuationToken)
 	}
 
-	_, err := uploader.Upload(&s3manager.UploadInput{
+	return result, nil
+}
+
+func (s *S3) Delete(ctx context.Context, remotePath string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
-		Key:    aws.String(key),
-		Body:   reader,
+		Key:    aws.String(s.key(remotePath)),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to upload stream, %v", err)
+		return fmt.Errorf("failed to delete object: %w", err)
 	}
 
 	return nil
 }
+
+func (s *S3) Stat(ctx context.Context, remotePath string) (*ObjectInfo, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(s.key(remotePath)),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	out, err := s.client.HeadObjectWithContext(ctx, input)
+	if err != nil {
+		// HeadObject has no body, so a missing key only surfaces as a 404
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	return &ObjectInfo{
+		Key:         remotePath,
+		Size:        aws.Int64Value(out.ContentLength),
+		ModTime:     aws.TimeValue(out.LastModified),
+		RetainUntil: aws.TimeValue(out.ObjectLockRetainUntilDate),
+		LegalHold:   aws.StringValue(out.ObjectLockLegalHoldStatus) == s3.ObjectLockLegalHoldStatusOn,
+	}, nil
+}
+
+func (s *S3) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := s.Stat(ctx, remotePath)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,564 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-process S3 endpoint for a single bucket. It records every
+// request and keeps the headers objects were uploaded with.
+type fakeS3 struct {
+	bucket string
+	tls    bool
+	server *httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	requests []fakeRequest
+}
+
+type fakeObject struct {
+	data     []byte
+	header   http.Header
+	modified time.Time
+}
+
+type fakeRequest struct {
+	Method string
+	Host   string
+	Path   string
+	Query  url.Values
+	Header http.Header
+}
+
+// newFakeS3 starts a fake S3 server, serving HTTPS if tls is set. Every
+// connection the default transport makes goes to it, so virtual-host
+// addressed buckets need no DNS.
+func newFakeS3(t *testing.T, bucket string, tls bool) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{bucket: bucket, tls: tls, objects: make(map[string]fakeObject)}
+	f.server = httptest.NewUnstartedServer(http.HandlerFunc(f.serve))
+	if tls {
+		f.server.StartTLS()
+	} else {
+		f.server.Start()
+	}
+	t.Cleanup(f.server.Close)
+
+	// A CA bundle from the environment would replace the transport
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, f.server.Listener.Addr().String())
+	}
+	original := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() { http.DefaultTransport = original })
+
+	return f
+}
+
+// endpoint is the fake's URL under a host name that has subdomains
+func (f *fakeS3) endpoint() string {
+	_, port, _ := net.SplitHostPort(f.server.Listener.Addr().String())
+	if f.tls {
+		return "https://s3.test:" + port
+	}
+	return "http://s3.test:" + port
+}
+
+// config returns a storage config that talks to the fake. Over TLS it skips
+// verification, since the test certificate isn't issued for s3.test.
+func (f *fakeS3) config() Config {
+	return Config{
+		Type:               "s3",
+		Bucket:             f.bucket,
+		BasePath:           "db",
+		Endpoint:           f.endpoint(),
+		AccessKey:          "AKIDTEST",
+		SecretKey:          "secret",
+		PathStyle:          true,
+		InsecureSkipVerify: f.tls,
+	}
+}
+
+// last returns the most recent request with the given method
+func (f *fakeS3) last(t *testing.T, method string) fakeRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].Method == method {
+			return f.requests[i]
+		}
+	}
+	t.Fatalf("no %s request was made", method)
+	return fakeRequest{}
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, fakeRequest{
+		Method: r.Method,
+		Host:   r.Host,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+	})
+
+	// Virtual-host requests name the bucket in the host, path-style ones
+	// in the first path segment
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.HasPrefix(r.Host, f.bucket+".") {
+		bucket, rest, _ := strings.Cut(key, "/")
+		if bucket != f.bucket {
+			s3Error(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		key = rest
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeObject{data: data, header: r.Header.Clone(), modified: time.Now().UTC()}
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		for _, h := range []string{"X-Amz-Object-Lock-Mode", "X-Amz-Object-Lock-Retain-Until-Date", "X-Amz-Object-Lock-Legal-Hold"} {
+			if v := obj.header.Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list answers ListObjectsV2, using the last key of a page as the token
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	type result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}
+
+	maxKeys := 1000
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil {
+		maxKeys = n
+	}
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, q.Get("prefix")) && k > q.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	res := result{Name: f.bucket, Prefix: q.Get("prefix"), MaxKeys: maxKeys}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		res.IsTruncated = true
+		res.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		obj := f.objects[k]
+		res.Contents = append(res.Contents, content{Key: k, LastModified: obj.modified.Format(time.RFC3339), Size: len(obj.data)})
+	}
+	res.KeyCount = len(res.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(res)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func TestS3Addressing(t *testing.T) {
+	tests := []struct {
+		name      string
+		pathStyle bool
+		wantHost  string
+		wantPath  string
+	}{
+		{name: "path style", pathStyle: true, wantHost: "s3.test", wantPath: "/backups/db/app.sql.gz"},
+		{name: "virtual host", pathStyle: false, wantHost: "backups.s3.test", wantPath: "/db/app.sql.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3(t, "backups", false)
+			cfg := fake.config()
+			cfg.PathStyle = tt.pathStyle
+			store, err := NewS3(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.StreamUpload(context.Background(), strings.NewReader("dump"), "app.sql.gz", UploadOptions{}); err != nil {
+				t.Fatalf("StreamUpload: %v", err)
+			}
+			req := fake.last(t, http.MethodPut)
+			if host, _, _ := net.SplitHostPort(req.Host); host != tt.wantHost {
+				t.Errorf("request host %q, want %q", req.Host, tt.wantHost)
+			}
+			if req.Path != tt.wantPath {
+				t.Errorf("request path %q, want %q", req.Path, tt.wantPath)
+			}
+
+			var buf strings.Builder
+			if err := store.StreamDownload(context.Background(), "app.sql.gz", &buf); err != nil {
+				t.Fatalf("StreamDownload: %v", err)
+			}
+			if buf.String() != "dump" {
+				t.Errorf("downloaded %q, want %q", buf.String(), "dump")
+			}
+		})
+	}
+}
+
+func TestS3UploadHeaders(t *testing.T) {
+	customerKey := []byte("0123456789abcdef0123456789abcdef")
+	keyMD5 := md5.Sum(customerKey)
+
+	tests := []struct {
+		name   string
+		tls    bool // the SDK refuses to send customer keys over HTTP
+		config func(*Config)
+		opts   UploadOptions
+		want   map[string]string
+		absent []string
+	}{
+		{
+			name:   "defaults",
+			config: func(c *Config) {},
+			absent: []string{"X-Amz-Server-Side-Encryption", "X-Amz-Storage-Class", "X-Amz-Tagging", "X-Amz-Object-Lock-Mode", "X-Amz-Security-Token"},
+		},
+		{
+			name:   "session token",
+			config: func(c *Config) { c.SessionToken = "session-token" },
+			want:   map[string]string{"X-Amz-Security-Token": "session-token"},
+		},
+		{
+			name:   "SSE-S3",
+			config: func(c *Config) { c.SSE = "AES256" },
+			want:   map[string]string{"X-Amz-Server-Side-Encryption": "AES256"},
+			absent: []string{"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"},
+		},
+		{
+			name:   "SSE-KMS",
+			config: func(c *Config) { c.SSE = "aws:kms"; c.KMSKeyID = "alias/backups" },
+			want: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "alias/backups",
+			},
+		},
+		{
+			name:   "SSE-C",
+			tls:    true,
+			config: func(c *Config) { c.SSE = SSECustomer; c.SSECustomerKey = customerKey },
+			want: map[string]string{
+				"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+				"X-Amz-Server-Side-Encryption-Customer-Key":       base64.StdEncoding.EncodeToString(customerKey),
+				"X-Amz-Server-Side-Encryption-Customer-Key-Md5":   base64.StdEncoding.EncodeToString(keyMD5[:]),
+			},
+			absent: []string{"X-Amz-Server-Side-Encryption"},
+		},
+		{
+			name:   "storage class",
+			config: func(c *Config) { c.StorageClass = "STANDARD_IA" },
+			want:   map[string]string{"X-Amz-Storage-Class": "STANDARD_IA"},
+		},
+		{
+			name:   "tags and metadata",
+			config: func(c *Config) { c.Tags = map[string]string{"job": "configured", "tier": "monthly"} },
+			opts: UploadOptions{
+				Tags:     map[string]string{"job": "nightly", "database": "postgres:app"},
+				Metadata: map[string]string{"sha256": "abc"},
+			},
+			want: map[string]string{
+				"X-Amz-Tagging":     "database=postgres%3Aapp&job=configured&tier=monthly",
+				"X-Amz-Meta-Sha256": "abc",
+			},
+		},
+		{
+			name:   "object lock",
+			config: func(c *Config) { c.LockMode = LockCompliance; c.LockPeriod = 24 * time.Hour; c.LegalHold = true },
+			want: map[string]string{
+				"X-Amz-Object-Lock-Mode":       "COMPLIANCE",
+				"X-Amz-Object-Lock-Legal-Hold": "ON",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3(t, "backups", tt.tls)
+			cfg := fake.config()
+			tt.config(&cfg)
+			store, err := NewS3(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.StreamUpload(context.Background(), strings.NewReader("dump"), "app.sql.gz", tt.opts); err != nil {
+				t.Fatalf("StreamUpload: %v", err)
+			}
+			req := fake.last(t, http.MethodPut)
+			for h, want := range tt.want {
+				if got := req.Header.Get(h); got != want {
+					t.Errorf("%s = %q, want %q", h, got, want)
+				}
+			}
+			for _, h := range tt.absent {
+				if got := req.Header.Get(h); got != "" {
+					t.Errorf("%s = %q, want it unset", h, got)
+				}
+			}
+		})
+	}
+}
+
+func TestS3LockRetention(t *testing.T) {
+	fake := newFakeS3(t, "backups", false)
+	cfg := fake.config()
+	cfg.LockMode = LockGovernance
+	cfg.LockPeriod = 30 * 24 * time.Hour
+	store, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if err := store.StreamUpload(context.Background(), strings.NewReader("dump"), "app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	req := fake.last(t, http.MethodPut)
+	if got := req.Header.Get("X-Amz-Object-Lock-Mode"); got != LockGovernance {
+		t.Errorf("lock mode %q, want %q", got, LockGovernance)
+	}
+	until, err := time.Parse(time.RFC3339, req.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	if err != nil {
+		t.Fatalf("retain until date: %v", err)
+	}
+	if want := before.Add(cfg.LockPeriod); until.Before(want.Add(-time.Second)) || until.After(want.Add(time.Minute)) {
+		t.Errorf("retain until %s, want about %s", until, want)
+	}
+
+	info, err := store.Stat(context.Background(), "app.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.RetainUntil.Equal(until) || info.LegalHold {
+		t.Errorf("Stat() retain until %s, legal hold %t; want %s, false", info.RetainUntil, info.LegalHold, until)
+	}
+	if !info.Locked(time.Now()) {
+		t.Error("Stat() reports the object unlocked")
+	}
+}
+
+func TestS3CustomerKeyOnReads(t *testing.T) {
+	fake := newFakeS3(t, "backups", true)
+	cfg := fake.config()
+	cfg.SSE = SSECustomer
+	cfg.SSECustomerKey = []byte("0123456789abcdef0123456789abcdef")
+	store, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := store.StreamUpload(ctx, strings.NewReader("dump"), "app.sql.gz", UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StreamDownload(ctx, "app.sql.gz", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, "app.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		if got := fake.last(t, method).Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"); got != "AES256" {
+			t.Errorf("%s customer algorithm %q, want AES256", method, got)
+		}
+	}
+}
+
+func TestS3NotFound(t *testing.T) {
+	fake := newFakeS3(t, "backups", false)
+	store, err := NewS3(fake.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := store.Stat(ctx, "missing.sql.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() error = %v, want ErrNotFound", err)
+	}
+	if ok, err := store.Exists(ctx, "missing.sql.gz"); ok || err != nil {
+		t.Errorf("Exists() = %t, %v, want false, nil", ok, err)
+	}
+	if err := store.StreamDownload(ctx, "missing.sql.gz", io.Discard); !errors.Is(err, ErrNotFound) {
+		t.Errorf("StreamDownload() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3ListAndDelete(t *testing.T) {
+	fake := newFakeS3(t, "backups", false)
+	store, err := NewS3(fake.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	want := []string{"a.sql.gz", "b.sql.gz", "c.sql.gz", "d.sql.gz", "e.sql.gz"}
+	for _, key := range want {
+		if err := store.StreamUpload(ctx, strings.NewReader(key), key, UploadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	pages := 0
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := store.List(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, obj := range page.Objects {
+			got = append(got, obj.Key)
+			if obj.Size != int64(len(obj.Key)) {
+				t.Errorf("%s: size %d, want %d", obj.Key, obj.Size, len(obj.Key))
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	if !slices.Equal(got, want) || pages != 3 {
+		t.Errorf("listed %v in %d pages, want %v in 3", got, pages, want)
+	}
+	if prefix := fake.last(t, http.MethodGet).Query.Get("prefix"); prefix != "db/" {
+		t.Errorf("list prefix %q, want %q", prefix, "db/")
+	}
+
+	if err := store.Delete(ctx, "a.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Exists(ctx, "a.sql.gz"); ok || err != nil {
+		t.Errorf("Exists() after Delete = %t, %v, want false, nil", ok, err)
+	}
+}
+
+func TestNewS3Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown SSE", config: Config{SSE: "rot13"}},
+		{name: "short customer key", config: Config{SSE: SSECustomer, SSECustomerKey: []byte("short")}},
+		{name: "KMS key without KMS", config: Config{SSE: "AES256", KMSKeyID: "alias/backups"}},
+		{name: "unknown lock mode", config: Config{LockMode: "FOREVER", LockPeriod: time.Hour}},
+		{name: "lock without period", config: Config{LockMode: LockGovernance}},
+		{name: "missing CA bundle", config: Config{CABundle: "/nonexistent/ca.pem"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Bucket = "backups"
+			tt.config.Endpoint = "http://127.0.0.1:1"
+			if _, err := NewS3(tt.config); err == nil {
+				t.Fatal("NewS3 succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestS3CABundle(t *testing.T) {
+	fake := newFakeS3(t, "backups", true)
+	ctx := context.Background()
+
+	// The test certificate is issued for 127.0.0.1, so verification can
+	// succeed when the endpoint is the listener's address
+	cfg := fake.config()
+	cfg.Endpoint = fake.server.URL
+	cfg.InsecureSkipVerify = false
+
+	untrusted, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := untrusted.Exists(ctx, "app.sql.gz"); err == nil {
+		t.Fatal("request to a server with an untrusted certificate succeeded")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fake.server.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CABundle = bundle
+	trusted, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := trusted.Exists(ctx, "app.sql.gz"); ok || err != nil {
+		t.Fatalf("Exists() = %t, %v, want false, nil", ok, err)
+	}
+}
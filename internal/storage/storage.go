@@ -1,17 +1,104 @@
 package storage
 
-import "io"
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
 
-// Storage interface defines the methods for storage backends
+// ErrNotFound is returned when an object does not exist in the storage
+var ErrNotFound = errors.New("object not found")
+
+// ErrLocked is returned when deleting an object whose retention hasn't expired
+var ErrLocked = errors.New("object is locked")
+
+// Object Lock retention modes. Governance retention can be lifted by users
+// with special permission; compliance retention can't be lifted by anyone.
+const (
+	LockGovernance = "GOVERNANCE"
+	LockCompliance = "COMPLIANCE"
+)
+
+// Storage interface defines the methods for storage backends. Transfers stop
+// when their context is cancelled.
 type Storage interface {
 	// Upload pushes a file to the storage
-	Upload(localPath string, remotePath string) error
+	Upload(ctx context.Context, localPath string, remotePath string, opts UploadOptions) error
 
 	// Download retrieves a file from the storage
-	Download(remotePath string, localPath string) error
+	Download(ctx context.Context, remotePath string, localPath string) error
 
 	// StreamUpload allows uploading from a reader (useful for piping compressed data)
-	StreamUpload(reader io.Reader, remotePath string) error
+	StreamUpload(ctx context.Context, reader io.Reader, remotePath string, opts UploadOptions) error
+
+	// StreamDownload writes the contents of a stored object to writer
+	StreamDownload(ctx context.Context, remotePath string, writer io.Writer) error
+
+	// List returns a page of objects, optionally filtered by key prefix
+	List(ctx context.Context, opts ListOptions) (*ListResult, error)
+
+	// Delete removes an object from the storage
+	Delete(ctx context.Context, remotePath string) error
+
+	// Stat returns metadata for an object, or ErrNotFound if it doesn't exist
+	Stat(ctx context.Context, remotePath string) (*ObjectInfo, error)
+
+	// Exists reports whether an object exists in the storage
+	Exists(ctx context.Context, remotePath string) (bool, error)
+}
+
+// ObjectInfo holds metadata about a stored object
+type ObjectInfo struct {
+	Key     string // path relative to the configured BasePath
+	Size    int64
+	ModTime time.Time
+
+	// Set by Stat; List leaves them empty for S3
+	RetainUntil time.Time // zero if the object has no retention
+	LegalHold   bool
+}
+
+// Locked reports whether the object can't be deleted at now
+func (o ObjectInfo) Locked(now time.Time) bool {
+	return o.LegalHold || o.RetainUntil.After(now)
+}
+
+// UploadOptions describes an object being uploaded. Backends that can't
+// store tags or metadata ignore them.
+type UploadOptions struct {
+	Tags     map[string]string // Merged with the configured Tags
+	Metadata map[string]string
+}
+
+// ListOptions controls which objects List returns
+type ListOptions struct {
+	Prefix    string // only return keys starting with this prefix
+	MaxKeys   int    // page size; 0 uses the backend default
+	PageToken string // NextPageToken from a previous page
+}
+
+// ListResult is a single page of listed objects
+type ListResult struct {
+	Objects       []ObjectInfo
+	NextPageToken string // empty when there are no more pages
+}
+
+// ListAll walks every page of List and returns all objects matching prefix
+func ListAll(ctx context.Context, s Storage, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	opts := ListOptions{Prefix: prefix}
+	for {
+		page, err := s.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Objects...)
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
 }
 
 // Config holds common storage configuration parameters
@@ -22,4 +109,34 @@ type Config struct {
 	BasePath  string // for local storage or prefix in cloud
 	AccessKey string
 	SecretKey string
+
+	CredentialsFile string // GCS service account key; empty uses Application Default Credentials
+	Endpoint        string // for S3-compatible services, a GCS private endpoint or an Azure service URL
+
+	// Azure only; Bucket names the container
+	AccountName      string
+	AccountKey       string
+	SASToken         string
+	ConnectionString string
+
+	// S3 only
+	SessionToken       string
+	PathStyle          bool   // address buckets as a path rather than a subdomain
+	CABundle           string // PEM file of the CAs to trust instead of the system ones
+	InsecureSkipVerify bool
+
+	SSE            string            // "AES256", "aws:kms" or "SSE-C"; empty leaves it to the bucket
+	KMSKeyID       string            // for aws:kms, or the Cloud KMS key for GCS; empty uses the provider managed key
+	SSECustomerKey []byte            // 32-byte key for SSE-C
+	StorageClass   string            // e.g. "STANDARD_IA", "GLACIER_IR"
+	Tags           map[string]string // added to every object
+
+	// Immutability. S3 uploads get Object Lock retention in LockMode for
+	// LockPeriod, which needs a bucket with Object Lock enabled. Local
+	// storage in WORM mode writes objects read-only, refuses to overwrite
+	// them and refuses to delete them for LockPeriod after they were written.
+	LockMode   string // S3: LockGovernance or LockCompliance
+	LockPeriod time.Duration
+	LegalHold  bool // S3: also place a legal hold, which lasts until removed
+	WORM       bool // local
 }